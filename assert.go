@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assertion on a metric of test results like "Read:p99<0.2"
+type assertion struct {
+	spec   string
+	op     string // all tests if empty
+	metric string
+	cmp    string
+	value  float64
+}
+
+var assertCmps = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+// Parse comma separated assertions
+func parseAssertions(s string) ([]assertion, error) {
+	ret := []assertion{}
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		a := assertion{spec: spec}
+		i := strings.IndexAny(spec, "<>=!")
+		if i < 0 {
+			return nil, fmt.Errorf("assertion %s has no comparison", spec)
+		}
+		for _, cmp := range assertCmps {
+			if strings.HasPrefix(spec[i:], cmp) {
+				a.cmp = cmp
+				break
+			}
+		}
+		if a.cmp == "" {
+			return nil, fmt.Errorf("assertion %s has unknown comparison", spec)
+		}
+		a.metric = strings.TrimSpace(spec[:i])
+		if j := strings.Index(a.metric, ":"); j >= 0 {
+			a.op, a.metric = a.metric[:j], a.metric[j+1:]
+		}
+		if a.metric == "" {
+			return nil, fmt.Errorf("assertion %s has no metric", spec)
+		}
+		a.metric = metricKey(a.metric)
+		var err error
+		a.value, err = strconv.ParseFloat(strings.TrimSpace(spec[i+len(a.cmp):]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("assertion %s: %v", spec, err)
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+func (a assertion) holds(v float64) bool {
+	switch a.cmp {
+	case "<=":
+		return v <= a.value
+	case ">=":
+		return v >= a.value
+	case "==":
+		return v == a.value
+	case "!=":
+		return v != a.value
+	case "<":
+		return v < a.value
+	}
+	return v > a.value
+}
+
+// Check the assertions against the tests, returns the assertions report and
+// whether all of them passed
+func checkAssertions(asserts []assertion, tests []map[string]interface{}) ([]map[string]interface{}, bool) {
+	passed := true
+	ret := []map[string]interface{}{}
+	for _, a := range asserts {
+		matched := false
+		for _, t := range tests {
+			op, _ := t["Operation"].(string)
+			if a.op != "" && !strings.EqualFold(a.op, op) {
+				continue
+			}
+			matched = true
+			rep := map[string]interface{}{"Assertion": a.spec, "Operation": op}
+			v, ok := reportNumber(t[a.metric])
+			if ok {
+				rep["Value"] = v
+			}
+			if ok && a.holds(v) {
+				rep["Result"] = "pass"
+			} else {
+				if !ok {
+					rep["Result"] = "fail: " + a.metric + " is not reported"
+				} else {
+					rep["Result"] = "fail"
+				}
+				passed = false
+			}
+			ret = append(ret, rep)
+		}
+		if !matched {
+			ret = append(ret, map[string]interface{}{"Assertion": a.spec, "Result": "fail: no such test"})
+			passed = false
+		}
+	}
+	return ret, passed
+}
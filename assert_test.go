@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Results of the assertions, eg "Read:p99<0.2 Read=pass"
+func assertionsSummary(report []map[string]interface{}) string {
+	s := []string{}
+	for _, rep := range report {
+		op, _ := rep["Operation"].(string)
+		s = append(s, fmt.Sprintf("%s %s=%s", rep["Assertion"], op, rep["Result"]))
+	}
+	return strings.Join(s, ";")
+}
+
+func TestParseAssertionsInvalid(t *testing.T) {
+	for _, spec := range []string{"x=1", "Read:<1", "p99<abc", "p99", "<1", "p99<"} {
+		if _, err := parseAssertions(spec); err == nil {
+			t.Errorf("%s: expected error", spec)
+		}
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	tests := []map[string]interface{}{
+		{"Operation": "Write", "Duration 99th-ile": 0.5, "Errors Count": int64(0)},
+		{"Operation": "Read", "Duration 99th-ile": 0.1, "Errors Count": int64(2), "Total Throughput (MB/s)": 600.0},
+	}
+	cases := []struct {
+		spec    string
+		summary string
+		passed  bool
+	}{
+		{"Read:p99<0.2", "Read:p99<0.2 Read=pass", true},
+		{"Read:p99<0.1", "Read:p99<0.1 Read=fail", false},
+		{"Read:p99<=0.1", "Read:p99<=0.1 Read=pass", true},
+		{"Read:throughput>500", "Read:throughput>500 Read=pass", true},
+		{"Read:throughput>600", "Read:throughput>600 Read=fail", false},
+		{"Read:throughput>=600", "Read:throughput>=600 Read=pass", true},
+		{"Write:errors==0", "Write:errors==0 Write=pass", true},
+		{"Read:errors==0", "Read:errors==0 Read=fail", false},
+		{"Read:errors!=0", "Read:errors!=0 Read=pass", true},
+		{"Write:errors!=0", "Write:errors!=0 Write=fail", false},
+		// every test is checked without operation
+		{"p99<0.3", "p99<0.3 Write=fail;p99<0.3 Read=pass", false},
+		{"errors<=2", "errors<=2 Write=pass;errors<=2 Read=pass", true},
+		{"read:p99 < 0.2", "read:p99 < 0.2 Read=pass", true},
+		{"Write:throughput>1", "Write:throughput>1 Write=fail: Total Throughput (MB/s) is not reported", false},
+		{"Copy:p99<1", "Copy:p99<1 =fail: no such test", false},
+		{"Read:p99<0.2,Write:errors==0", "Read:p99<0.2 Read=pass;Write:errors==0 Write=pass", true},
+	}
+	for _, c := range cases {
+		asserts, err := parseAssertions(c.spec)
+		if err != nil {
+			t.Errorf("%s: %v", c.spec, err)
+			continue
+		}
+		report, passed := checkAssertions(asserts, tests)
+		if s := assertionsSummary(report); s != c.summary || passed != c.passed {
+			t.Errorf("%s: got %s passed %v, expected %s passed %v", c.spec, s, passed, c.summary, c.passed)
+		}
+	}
+}
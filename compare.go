@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Report metrics by short names used in thresholds, other names are taken
+// as report keys as is
+var metricAliases = map[string]string{
+	"throughput": "Total Throughput (MB/s)",
+	"requests":   "Total Requests Count",
+	"errors":     "Errors Count",
+	"retried":    "Retried Requests Count",
+	"retries":    "Retries Count",
+	"avg":        "Duration Avg",
+	"min":        "Duration Min",
+	"max":        "Duration Max",
+	"p25":        "Duration 25th-ile",
+	"p50":        "Duration 50th-ile",
+	"p75":        "Duration 75th-ile",
+	"p90":        "Duration 90th-ile",
+	"p99":        "Duration 99th-ile",
+	"p99.9":      "Duration 99.9th-ile",
+	"p99.99":     "Duration 99.99th-ile",
+	"ttfb_avg":   "Ttfb Avg",
+	"ttfb_min":   "Ttfb Min",
+	"ttfb_max":   "Ttfb Max",
+	"ttfb_p50":   "Ttfb 50th-ile",
+	"ttfb_p90":   "Ttfb 90th-ile",
+	"ttfb_p99":   "Ttfb 99th-ile",
+}
+
+// metrics compared by default
+var compareMetrics = []string{"throughput", "avg", "p25", "p50", "p75", "p90", "p99", "p99.9", "p99.99", "max"}
+
+func metricKey(name string) string {
+	if key, ok := metricAliases[name]; ok {
+		return key
+	}
+	return name
+}
+
+// Max allowed change of a metric against the baseline
+type compareThreshold struct {
+	op     string // all operations if empty
+	metric string
+	limit  float64 // percent, negative value limits decrease of the metric
+}
+
+// Parse thresholds like "p99=+10%,Read:throughput=-5%"
+func parseThresholds(s string) ([]compareThreshold, error) {
+	ret := []compareThreshold{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		kv := strings.SplitN(t, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("threshold %s should be metric=+N%% or metric=-N%%", t)
+		}
+		ct := compareThreshold{metric: strings.TrimSpace(kv[0])}
+		if i := strings.Index(ct.metric, ":"); i >= 0 {
+			ct.op, ct.metric = ct.metric[:i], ct.metric[i+1:]
+		}
+		ct.metric = metricKey(ct.metric)
+		val := strings.TrimSuffix(strings.TrimSpace(kv[1]), "%")
+		if !strings.HasPrefix(val, "+") && !strings.HasPrefix(val, "-") {
+			return nil, fmt.Errorf("threshold %s should have explicit sign", t)
+		}
+		var err error
+		ct.limit, err = strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("threshold %s: %v", t, err)
+		}
+		ret = append(ret, ct)
+	}
+	return ret, nil
+}
+
+// Load tests of a report printed with -jsonOutput
+func loadReportTests(fileName string) ([]map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	report := struct {
+		Tests []map[string]interface{}
+	}{}
+	if err = json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return report.Tests, nil
+}
+
+// Numeric value of the report field
+func reportNumber(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	}
+	return 0, false
+}
+
+// Test of a report: scenario phase, operation and number of the earlier
+// tests with the same phase and operation, eg the Write of -mix after the
+// Write test
+type testKey struct {
+	name string
+	op   string
+	n    int
+}
+
+func (k testKey) String() string {
+	s := k.op
+	if k.name != "" {
+		s = k.name + ": " + s
+	}
+	if k.n > 0 {
+		s = fmt.Sprintf("%s #%d", s, k.n+1)
+	}
+	return s
+}
+
+// Keys of the tests in the order of the tests
+func testKeys(tests []map[string]interface{}) []testKey {
+	ret := make([]testKey, 0, len(tests))
+	seen := make(map[testKey]int)
+	for _, t := range tests {
+		op, _ := t["Operation"].(string)
+		name, _ := t["Name"].(string)
+		k := testKey{name: name, op: op}
+		k.n = seen[k]
+		seen[k]++
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// Compare tests with the baseline tests of the same phase and operation,
+// repeated tests are matched in order. Returns the comparison report and
+// whether any threshold is exceeded
+func compareTests(baseline, tests []map[string]interface{}, thresholds []compareThreshold) ([]map[string]interface{}, bool) {
+	keys := []testKey{}
+	// baseline and current test of the key
+	byKey := make(map[testKey][2]map[string]interface{})
+	match := func(side int, tt []map[string]interface{}) {
+		for i, k := range testKeys(tt) {
+			pair, ok := byKey[k]
+			if !ok {
+				keys = append(keys, k)
+			}
+			pair[side] = tt[i]
+			byKey[k] = pair
+		}
+	}
+	match(0, baseline)
+	match(1, tests)
+
+	failed := false
+	ret := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		op := k.op
+		rep := map[string]interface{}{"Operation": op, "Test": k.String()}
+		if k.name != "" {
+			rep["Name"] = k.name
+		}
+		ret = append(ret, rep)
+		base, cur := byKey[k][0], byKey[k][1]
+		if base == nil || cur == nil {
+			// not matched, eg -ops or -mix changed
+			if base == nil {
+				rep["Missing"] = "baseline"
+			} else {
+				rep["Missing"] = "current"
+			}
+			continue
+		}
+
+		keys := []string{}
+		for _, m := range compareMetrics {
+			keys = append(keys, metricKey(m))
+		}
+		for _, ct := range thresholds {
+			if indexOf(keys, ct.metric) < 0 {
+				keys = append(keys, ct.metric)
+			}
+		}
+
+		exceeded := []string{}
+		for _, key := range keys {
+			bv, bok := reportNumber(base[key])
+			cv, cok := reportNumber(cur[key])
+			if !bok || !cok {
+				continue
+			}
+			delta := map[string]interface{}{"Baseline": bv, "Current": cv}
+			if bv != 0 {
+				delta["Change (%)"] = (cv - bv) / bv * 100
+			}
+			rep[key] = delta
+			for _, ct := range thresholds {
+				if ct.metric != key || (ct.op != "" && ct.op != op) {
+					continue
+				}
+				if !thresholdExceeded(bv, cv, ct.limit) {
+					continue
+				}
+				if bv == 0 {
+					exceeded = append(exceeded, fmt.Sprintf("%s grew from 0 to %g, allowed %+g%%", key, cv, ct.limit))
+				} else {
+					exceeded = append(exceeded, fmt.Sprintf("%s changed %+.2f%%, allowed %+g%%", key, (cv-bv)/bv*100, ct.limit))
+				}
+			}
+		}
+		if len(thresholds) > 0 {
+			rep["Exceeded Thresholds"] = exceeded
+			failed = failed || len(exceeded) > 0
+		}
+	}
+	return ret, failed
+}
+
+func thresholdExceeded(base, cur float64, limit float64) bool {
+	if base == 0 {
+		// any growth from zero is infinite
+		return limit >= 0 && cur > 0
+	}
+	change := (cur - base) / base * 100
+	if limit >= 0 {
+		return change > limit
+	}
+	return change < limit
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func reportTest(name, op string, p99 float64) map[string]interface{} {
+	t := map[string]interface{}{"Operation": op, "Duration 99th-ile": p99}
+	if name != "" {
+		t["Name"] = name
+	}
+	return t
+}
+
+// Tests of the comparison with p99 change or the missing side, eg
+// "Write=+10%;Read=missing current"
+func comparisonSummary(comparison []map[string]interface{}) string {
+	s := []string{}
+	for _, rep := range comparison {
+		if m, ok := rep["Missing"]; ok {
+			s = append(s, fmt.Sprintf("%s=missing %s", rep["Test"], m))
+			continue
+		}
+		delta := rep["Duration 99th-ile"].(map[string]interface{})
+		s = append(s, fmt.Sprintf("%s=%+.0f%%", rep["Test"], delta["Change (%)"]))
+	}
+	return strings.Join(s, ";")
+}
+
+func TestCompareTests(t *testing.T) {
+	thresholds, err := parseThresholds("p99=+10%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type tests = []map[string]interface{}
+	cases := []struct {
+		desc     string
+		baseline tests
+		current  tests
+		summary  string
+		failed   bool
+	}{
+		{"same",
+			tests{reportTest("", "Write", 1), reportTest("", "Read", 1)},
+			tests{reportTest("", "Write", 1), reportTest("", "Read", 1)},
+			"Write=+0%;Read=+0%", false},
+		{"regression",
+			tests{reportTest("", "Write", 1), reportTest("", "Read", 1)},
+			tests{reportTest("", "Write", 1), reportTest("", "Read", 1.5)},
+			"Write=+0%;Read=+50%", true},
+		{"repeated operation regression",
+			tests{reportTest("", "Write", 1), reportTest("", "Read", 1), reportTest("", "Write", 1), reportTest("", "Mix", 1)},
+			tests{reportTest("", "Write", 1), reportTest("", "Read", 1), reportTest("", "Write", 2), reportTest("", "Mix", 1)},
+			"Write=+0%;Read=+0%;Write #2=+100%;Mix=+0%", true},
+		{"phases",
+			tests{reportTest("warmup", "Write", 4), reportTest("load", "Write", 1)},
+			tests{reportTest("load", "Write", 1.05), reportTest("warmup", "Write", 1)},
+			"warmup: Write=-75%;load: Write=+5%", false},
+		{"unmatched",
+			tests{reportTest("", "Write", 1), reportTest("", "Write", 1), reportTest("", "Read", 1)},
+			tests{reportTest("", "Write", 1), reportTest("", "HeadObj", 1)},
+			"Write=+0%;Write #2=missing current;Read=missing current;HeadObj=missing baseline", false},
+	}
+	for _, c := range cases {
+		comparison, failed := compareTests(c.baseline, c.current, thresholds)
+		if s := comparisonSummary(comparison); s != c.summary || failed != c.failed {
+			t.Errorf("%s: got %s failed %v, expected %s failed %v", c.desc, s, failed, c.summary, c.failed)
+		}
+	}
+}
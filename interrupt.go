@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Stop the tests on SIGINT/SIGTERM to report completed requests and clean
+// up, the second signal exits immediately
+func handleSignals(cancel func()) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Fprintf(os.Stderr, "Got %v, waiting for in-flight requests, repeat to exit immediately\n", sig)
+		cancel()
+		sig = <-sigs
+		fmt.Fprintf(os.Stderr, "Got %v, exiting without cleanup\n", sig)
+		os.Exit(1)
+	}()
+}
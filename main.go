@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Seagate/s3bench/s3bench"
+)
+
+var (
+	gitHash   string
+	buildDate string
+)
+
+// Run the in-memory S3 with command line args following "serve", never returns
+func serveStub(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":9000", "address to listen on")
+	latency := fs.Duration("latency", 0, "latency added to every request, eg: 5ms")
+	jitter := fs.Duration("jitter", 0, "random latency added to every request up to given value, eg: 2ms")
+	errorRate := fs.Float64("errorRate", 0, "share of requests failed with 503 SlowDown, eg: 0.01")
+	fs.Parse(args)
+
+	if *latency < 0 || *jitter < 0 {
+		fmt.Println("-latency and -jitter cannot be negative")
+		os.Exit(1)
+	}
+	if *errorRate < 0 || *errorRate > 1 {
+		fmt.Println("-errorRate should be in range [0..1]")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "In-memory S3 is listening on %s\n", *addr)
+	err := http.ListenAndServe(*addr, s3bench.NewStubServer(*latency, *jitter, *errorRate))
+	fmt.Printf("Server failed: %v\n", err)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serveStub(os.Args[2:])
+	}
+
+	def := s3bench.DefaultConfig()
+	endpoint := flag.String("endpoint", "", "S3 endpoint(s) comma separated - http://IP:PORT,http://IP:PORT")
+	region := flag.String("region", def.Region, "AWS region to use, eg: us-west-1|us-east-1, etc")
+	accessKey := flag.String("accessKey", "", "the S3 access key")
+	accessSecret := flag.String("accessSecret", "", "the S3 access secret")
+	bucketName := flag.String("bucket", def.Bucket, "the bucket for which to run the test")
+	objectNamePrefix := flag.String("objectNamePrefix", def.ObjectNamePrefix, "prefix of the object name that will be used")
+	objectSize := flag.String("objectSize", def.ObjectSize, "size of individual requests (must be smaller than main memory), or size distribution: uniform:4Kb-16Mb|lognormal:mean,stddev|4Kb:60,1Mb:30,64Mb:10")
+	sizeSeed := flag.Int64("sizeSeed", def.SizeSeed, "seed of random object sizes, use the same value to read objects of the previous run")
+	numClients := flag.Int("numClients", def.NumClients, "number of concurrent clients")
+	numSamples := flag.Int("numSamples", def.NumSamples, "total number of requests to send")
+	skipCleanup := flag.Bool("skipCleanup", false, "skip deleting objects created by this tool at the end of the run")
+	verbose := flag.Bool("verbose", false, "print verbose per thread status")
+	headObj := flag.Bool("headObj", false, "head-object request instead of reading obj content")
+	sampleReads := flag.Int("sampleReads", def.SampleReads, "number of reads of each sample")
+	clientDelay := flag.Int("clientDelay", def.ClientDelay, "delay in ms before client starts. if negative value provided delay will be randomized in interval [0, abs{clientDelay})")
+	jsonOutput := flag.Bool("jsonOutput", false, "print results in forma of json")
+	deleteAtOnce := flag.Int("deleteAtOnce", def.DeleteAtOnce, "number of objs to delete at once")
+	deleteMode := flag.String("deleteMode", def.DeleteMode, "delete objects during cleanup one by one or by batches of deleteAtOnce: single|batch")
+	putObjTag := flag.Bool("putObjTag", false, "put object's tags")
+	getObjTag := flag.Bool("getObjTag", false, "get object's tags")
+	numTags := flag.Int("numTags", def.NumTags, "number of tags to create, for objects it should in range [1..10]")
+	tagNamePrefix := flag.String("tagNamePrefix", def.TagNamePrefix, "prefix of the tag name that will be used")
+	tagValPrefix := flag.String("tagValPrefix", def.TagValPrefix, "prefix of the tag value that will be used")
+	version := flag.Bool("version", false, "print version info")
+	agentAddr := flag.String("agent", "", "run as agent of -controller listening on the address, eg: :7000")
+	controller := flag.String("controller", "", "run the tests on comma separated agents and report merged results, eg: host1:7000,host2:7000")
+	metricsAddr := flag.String("metricsAddr", "", "serve Prometheus metrics of the running tests on the address, eg: :9100")
+	traceFile := flag.String("traceFile", "", "write record of every completed request to the file")
+	traceFormat := flag.String("traceFormat", def.TraceFormat, "format of the trace file: csv|jsonl")
+	histogramFile := flag.String("histogramFile", "", "save latency histograms of the tests to the file")
+	mergeHistograms := flag.String("mergeHistograms", "", "print percentiles of histograms merged from comma separated files saved by -histogramFile and exit")
+	compare := flag.String("compare", "", "compare results with the baseline report saved with -jsonOutput")
+	compareReport := flag.String("compareReport", "", "compare the report saved with -jsonOutput with -compare baseline instead of running tests and exit")
+	compareThresholds := flag.String("compareThresholds", "", "fail when a metric changes more than allowed against -compare baseline, eg: p99=+10%,throughput=-5%,Read:p50=+20%")
+	assert := flag.String("assert", "", "fail when the results do not meet comma separated assertions, eg: Read:p99<0.2,Write:errors==0,Read:throughput>500")
+	reportFormat := flag.String("reportFormat", "Version;Parameters;Parameters:numClients;Parameters:numSamples;Parameters:objectSize (MB);Parameters:sampleReads;Parameters:clientDelay;Parameters:readObj;Parameters:headObj;Parameters:putObjTag;Parameters:getObjTag;Tests:Operation;Tests:Total Requests Count;Tests:Errors Count;Tests:Total Throughput (MB/s);Tests:Duration Max;Tests:Duration Avg;Tests:Duration Min;Tests:Ttfb Max;Tests:Ttfb Avg;Tests:Ttfb Min;-Tests:Duration 25th-ile;-Tests:Duration 50th-ile;-Tests:Duration 75th-ile;-Tests:Ttfb 25th-ile;-Tests:Ttfb 50th-ile;-Tests:Ttfb 75th-ile;Comparison:Operation;Assertions:Assertion;Assertions:Operation;Assertions:Value;Assertions:Result;", "rearrange output fields")
+	validate := flag.Bool("validate", false, "validate stored data")
+	skipWrite := flag.Bool("skipWrite", false, "do not run Write test")
+	skipRead := flag.Bool("skipRead", false, "do not run Read test")
+	partSize := flag.String("partSize", "", "run Write test as multipart upload with parts of given size, eg: 5Mb")
+	partConcurrency := flag.Int("partConcurrency", def.PartConcurrency, "number of parts of a multipart upload sent concurrently by each client")
+	rangeSize := flag.String("rangeSize", "", "run Read test as ranged reads of given size, eg: 64Kb")
+	rangePattern := flag.String("rangePattern", def.RangePattern, "offset pattern of ranged reads: fixed|random|sequential")
+	rangeOffset := flag.String("rangeOffset", def.RangeOffset, "offset of ranged reads for fixed pattern")
+	rate := flag.Float64("rate", 0, "target rate of requests (ops/s), 0 means clients send requests as fast as possible")
+	rateDist := flag.String("rateDist", def.RateDist, "arrival distribution of rate limited requests: constant|poisson")
+	interval := flag.Duration("interval", 0, "print throughput and latency of every interval to stderr and add them to the report as Timeline, eg: 10s")
+	duration := flag.Duration("duration", 0, "run each test for given time instead of fixed number of samples, eg: 30m")
+	copyObj := flag.Bool("copyObj", false, "run Copy test which copies objects on server side")
+	copyBucket := flag.String("copyBucket", "", "destination bucket of Copy test, the same bucket by default")
+	copyPartSize := flag.String("copyPartSize", "", "copy objects larger than given size by multipart UploadPartCopy, eg: 64Mb")
+	listObj := flag.Bool("listObj", false, "run List test which lists all written objects page by page")
+	listSamples := flag.Int("listSamples", def.ListSamples, "number of listings in List test")
+	listMaxKeys := flag.Int("listMaxKeys", def.ListMaxKeys, "max number of keys in a page of List test")
+	listDelimiter := flag.String("listDelimiter", "", "delimiter of List test")
+	listStartAfter := flag.String("listStartAfter", "", "key to start List test after")
+	keyDist := flag.String("keyDist", def.KeyDist, "distribution of keys accessed by read, head and get tags tests: sequential|uniform|zipf:skew|hotspot:trafficPercent,keysPercent")
+	scenario := flag.String("scenario", "", "run phases described in JSON scenario file instead of the tests selected by flags")
+	ops := flag.String("ops", "", "run comma separated operations one by one instead of the tests selected by flags, eg: write,list,read,delete. Operations: "+strings.Join(s3bench.OperationNames(), ", "))
+	requestTimeout := flag.Duration("requestTimeout", 0, "timeout of every HTTP request including reading of the response, timed out requests are retried, eg: 30s")
+	maxRetries := flag.Int("maxRetries", def.MaxRetries, "max number of retries of failed HTTP requests, 0 disables retries")
+	retryMinDelay := flag.Duration("retryMinDelay", 0, "min backoff delay before retry, SDK default if not set, eg: 10ms")
+	retryMaxDelay := flag.Duration("retryMaxDelay", 0, "max backoff delay before retry, SDK default if not set, eg: 1s")
+	mix := flag.String("mix", "", "run weighted mix of operations concurrently instead of tag, head and read tests, eg: read=70,write=20,head=10")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s-%s\n", buildDate, gitHash)
+		os.Exit(0)
+	}
+
+	if *agentAddr != "" {
+		err := s3bench.ServeAgent(*agentAddr)
+		fmt.Printf("Agent failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *mergeHistograms != "" {
+		tests, err := s3bench.MergeHistogramFiles(strings.Split(*mergeHistograms, ","))
+		if err != nil {
+			fmt.Printf("Cannot merge histograms: %v\n", err)
+			os.Exit(1)
+		}
+		testreps := make([]map[string]interface{}, 0, len(tests))
+		for _, r := range tests {
+			testreps = append(testreps, r.Report())
+		}
+		reportPrint(map[string]interface{}{"Tests": testreps}, *jsonOutput, *reportFormat)
+		os.Exit(0)
+	}
+
+	var baseline []map[string]interface{}
+	thresholds, err := parseThresholds(*compareThresholds)
+	if err != nil {
+		fmt.Printf("Invalid -compareThresholds value: %v\n", err)
+		os.Exit(1)
+	}
+	if *compare != "" {
+		baseline, err = loadReportTests(*compare)
+		if err != nil {
+			fmt.Printf("Cannot load baseline report: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *compareReport != "" || len(thresholds) > 0 {
+		fmt.Println("-compareReport and -compareThresholds require -compare baseline")
+		os.Exit(1)
+	}
+
+	asserts, err := parseAssertions(*assert)
+	if err != nil {
+		fmt.Printf("Invalid -assert value: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *compareReport != "" {
+		tests, err := loadReportTests(*compareReport)
+		if err != nil {
+			fmt.Printf("Cannot load report: %v\n", err)
+			os.Exit(1)
+		}
+		report := make(map[string]interface{})
+		var failed bool
+		report["Comparison"], failed = compareTests(baseline, tests, thresholds)
+		if len(asserts) > 0 {
+			var passed bool
+			report["Assertions"], passed = checkAssertions(asserts, tests)
+			failed = failed || !passed
+		}
+		reportPrint(report, *jsonOutput, *reportFormat)
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *endpoint == "" {
+		fmt.Println("You need to specify endpoint(s)")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	c := s3bench.Config{
+		AccessKey:        *accessKey,
+		AccessSecret:     *accessSecret,
+		Region:           *region,
+		Endpoints:        strings.Split(*endpoint, ","),
+		Bucket:           *bucketName,
+		ObjectNamePrefix: *objectNamePrefix,
+		ObjectSize:       *objectSize,
+		SizeSeed:         *sizeSeed,
+		NumClients:       *numClients,
+		NumSamples:       *numSamples,
+		SampleReads:      *sampleReads,
+		ClientDelay:      *clientDelay,
+		Verbose:          *verbose,
+		SkipCleanup:      *skipCleanup,
+		SkipWrite:        *skipWrite,
+		SkipRead:         *skipRead,
+		ReadObj:          !(*putObjTag || *getObjTag || *headObj) && !*skipRead,
+		HeadObj:          *headObj,
+		PutObjTag:        *putObjTag || *getObjTag,
+		GetObjTag:        *getObjTag,
+		NumTags:          *numTags,
+		TagNamePrefix:    *tagNamePrefix,
+		TagValPrefix:     *tagValPrefix,
+		Validate:         *validate,
+		DeleteAtOnce:     *deleteAtOnce,
+		DeleteMode:       *deleteMode,
+		PartSize:         *partSize,
+		PartConcurrency:  *partConcurrency,
+		RangeSize:        *rangeSize,
+		RangeOffset:      *rangeOffset,
+		RangePattern:     *rangePattern,
+		Mix:              *mix,
+		Duration:         *duration,
+		Interval:         *interval,
+		Rate:             *rate,
+		RateDist:         *rateDist,
+		KeyDist:          *keyDist,
+		CopyObj:          *copyObj,
+		CopyBucket:       *copyBucket,
+		CopyPartSize:     *copyPartSize,
+		ListObj:          *listObj,
+		ListSamples:      *listSamples,
+		ListMaxKeys:      *listMaxKeys,
+		ListDelimiter:    *listDelimiter,
+		ListStartAfter:   *listStartAfter,
+		Ops:              *ops,
+		RequestTimeout:   *requestTimeout,
+		MaxRetries:       *maxRetries,
+		RetryMinDelay:    *retryMinDelay,
+		RetryMaxDelay:    *retryMaxDelay,
+		ScenarioFile:     *scenario,
+		TraceFile:        *traceFile,
+		TraceFormat:      *traceFormat,
+		MetricsAddr:      *metricsAddr,
+	}
+	if *controller != "" {
+		c.Agents = strings.Split(*controller, ",")
+	}
+
+	runner, err := s3bench.NewRunner(c)
+	if err != nil {
+		fmt.Printf("Cannot start tests: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handleSignals(cancel)
+
+	testResults, err := runner.Run(ctx)
+	runner.Close()
+	failed := false
+	if err != nil && ctx.Err() == nil {
+		fmt.Printf("Tests failed: %v\n", err)
+		failed = true
+	}
+
+	if *histogramFile != "" {
+		if err := s3bench.SaveHistograms(*histogramFile, testResults); err != nil {
+			fmt.Printf("Cannot save histograms: %v\n", err)
+		}
+	}
+
+	report := runner.Report(testResults)
+	report["Version"] = fmt.Sprintf("%s-%s", buildDate, gitHash)
+	parameters := report["Parameters"].(map[string]interface{})
+	parameters["jsonOutput"] = *jsonOutput
+	parameters["reportFormat"] = *reportFormat
+	if *compare != "" {
+		var exceeded bool
+		report["Comparison"], exceeded = compareTests(baseline, report["Tests"].([]map[string]interface{}), thresholds)
+		failed = failed || exceeded
+	}
+	if len(asserts) > 0 {
+		var passed bool
+		report["Assertions"], passed = checkAssertions(asserts, report["Tests"].([]map[string]interface{}))
+		failed = failed || !passed
+	}
+	reportPrint(report, *jsonOutput, *reportFormat)
+	if failed || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
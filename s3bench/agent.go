@@ -0,0 +1,497 @@
+package s3bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// step of agents to delete the buckets after all of them deleted objects
+const stepDeleteBuckets = "DeleteBuckets"
+
+// client ids of an agent are less than that, so ids of clients of all
+// agents do not overlap in the merged results
+const agentMaxClients = 1 << 20
+
+// Step of the tests the agent waits at
+type agentStep struct {
+	Step     string
+	Finished bool
+	Error    string
+}
+
+// Tests run by the agent, every test is a step started by the controller
+// when all agents are ready
+type agentRun struct {
+	steps         chan string
+	start         chan struct{}
+	results       chan []testResult
+	finished      chan struct{}
+	err           error // set before finished is closed
+	interrupted   chan struct{}
+	interruptOnce sync.Once
+}
+
+// Wait for the controller to start the step, no-op without controller
+func (params *Params) stepStart(name string) {
+	if params.agent == nil {
+		return
+	}
+	if params.phase != "" {
+		name = params.phase + ": " + name
+	}
+	params.agent.steps <- name
+	<-params.agent.start
+}
+
+// Send results of the step to the controller
+func (params *Params) stepDone(results []testResult) {
+	if params.agent == nil {
+		return
+	}
+	params.agent.results <- results
+}
+
+func (run *agentRun) run(params *Params, phases []scenarioPhase, c Config) {
+	defer close(run.finished)
+	runMtx.Lock()
+	defer runMtx.Unlock()
+	_, run.err = params.runSafe(c.awsConfig(), phases, c.SkipCleanup)
+}
+
+// Agent runs the tests pushed by the controller in lockstep with other
+// agents
+type agent struct {
+	mtx sync.Mutex
+	run *agentRun
+}
+
+// ServeAgent serves requests of the controller on addr, it returns only
+// when the server fails
+func ServeAgent(addr string) error {
+	a := &agent{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", a.handleStart)
+	mux.HandleFunc("/step", a.handleStep)
+	mux.HandleFunc("/go", a.handleGo)
+	mux.HandleFunc("/interrupt", a.handleInterrupt)
+	fmt.Fprintf(os.Stderr, "Agent is listening on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func (a *agent) current() *agentRun {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.run
+}
+
+func (a *agent) handleStart(w http.ResponseWriter, r *http.Request) {
+	c := Config{}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params, phases, err := c.params()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.run != nil {
+		select {
+		case <-a.run.finished:
+		default:
+			http.Error(w, "tests are already running", http.StatusConflict)
+			return
+		}
+	}
+	a.run = &agentRun{
+		steps:       make(chan string),
+		start:       make(chan struct{}),
+		results:     make(chan []testResult),
+		finished:    make(chan struct{}),
+		interrupted: params.interrupted,
+	}
+	params.agent = a.run
+	go a.run.run(params, phases, c)
+}
+
+func (a *agent) handleStep(w http.ResponseWriter, r *http.Request) {
+	run := a.current()
+	if run == nil {
+		http.Error(w, "no tests", http.StatusConflict)
+		return
+	}
+	st := agentStep{}
+	select {
+	case st.Step = <-run.steps:
+	case <-run.finished:
+		st.Finished = true
+		if run.err != nil {
+			st.Error = run.err.Error()
+		}
+	}
+	json.NewEncoder(w).Encode(st)
+}
+
+func (a *agent) handleGo(w http.ResponseWriter, r *http.Request) {
+	run := a.current()
+	if run == nil {
+		http.Error(w, "no tests", http.StatusConflict)
+		return
+	}
+	select {
+	case run.start <- struct{}{}:
+	case <-run.finished:
+		http.Error(w, "tests are finished", http.StatusConflict)
+		return
+	}
+	select {
+	case results := <-run.results:
+		exp := make([]resultExport, 0, len(results))
+		for _, res := range results {
+			exp = append(exp, exportResult(res))
+		}
+		json.NewEncoder(w).Encode(exp)
+	case <-run.finished:
+		http.Error(w, fmt.Sprintf("tests failed: %v", run.err), http.StatusInternalServerError)
+	}
+}
+
+func (a *agent) handleInterrupt(w http.ResponseWriter, r *http.Request) {
+	if run := a.current(); run != nil {
+		run.interruptOnce.Do(func() { close(run.interrupted) })
+	}
+}
+
+// Result of a test sent by the agent to the controller
+type resultExport struct {
+	Operation        string
+	Name             string
+	BytesTransmitted int64
+	TotalDuration    time.Duration
+	Durations        *Histogram
+	Ttfb             *Histogram
+	PartDurations    *Histogram
+	PartTtfb         *Histogram
+	Errors           []string
+	NumErrors        int64
+	NumFailed        int64
+	ErrorClasses     map[string]errorClassExport
+	TargetRate       float64
+	KeyDist          string
+	NumKeys          int64
+	Partial          bool
+	Endpoints        map[string]endpointExport
+	NumRetried       int64
+	NumRetries       int64
+	RetriedDurations *Histogram
+}
+
+type errorClassExport struct {
+	Count    int64
+	Examples []string
+}
+
+type endpointExport struct {
+	NumOps    int64
+	NumErrors int64
+	NumBytes  int64
+	Clients   []uint
+	Durations *Histogram
+	Ttfb      *Histogram
+}
+
+func exportResult(r testResult) resultExport {
+	e := resultExport{
+		Operation:        r.operation,
+		Name:             r.name,
+		BytesTransmitted: r.bytesTransmitted,
+		TotalDuration:    r.totalDuration,
+		Durations:        r.opDurations,
+		Ttfb:             r.opTtfb,
+		PartDurations:    r.partDurations,
+		PartTtfb:         r.partTtfb,
+		Errors:           r.opErrors,
+		NumErrors:        r.numErrors,
+		NumFailed:        r.numFailed,
+		ErrorClasses:     make(map[string]errorClassExport),
+		TargetRate:       r.targetRate,
+		KeyDist:          r.keyDist,
+		NumKeys:          r.numKeys,
+		Partial:          r.partial,
+		Endpoints:        make(map[string]endpointExport),
+		NumRetried:       r.numRetried,
+		NumRetries:       r.numRetries,
+		RetriedDurations: r.retriedDurations,
+	}
+	for class, ec := range r.errorClasses {
+		e.ErrorClasses[class] = errorClassExport{ec.count, ec.examples}
+	}
+	for name, es := range r.endpoints {
+		ee := endpointExport{
+			NumOps:    es.numOps,
+			NumErrors: es.numErrors,
+			NumBytes:  es.numBytes,
+			Durations: es.durations,
+			Ttfb:      es.ttfb,
+		}
+		for id := range es.clients {
+			ee.Clients = append(ee.Clients, id)
+		}
+		e.Endpoints[name] = ee
+	}
+	return e
+}
+
+// Result of the agent with ids of its clients starting at clientBase
+func (e resultExport) result(clientBase uint, keepErrors bool) testResult {
+	r := newResult(e.Operation)
+	r.name = e.Name
+	r.bytesTransmitted = e.BytesTransmitted
+	r.totalDuration = e.TotalDuration
+	for _, hh := range [][2]*Histogram{
+		{r.opDurations, e.Durations},
+		{r.opTtfb, e.Ttfb},
+		{r.partDurations, e.PartDurations},
+		{r.partTtfb, e.PartTtfb},
+		{r.retriedDurations, e.RetriedDurations},
+	} {
+		if hh[1] != nil {
+			hh[0].merge(hh[1])
+		}
+	}
+	r.keepErrors = keepErrors
+	if keepErrors {
+		r.opErrors = e.Errors
+	}
+	r.numErrors = e.NumErrors
+	r.numFailed = e.NumFailed
+	r.errorClasses = make(map[string]*errorClass)
+	for class, ec := range e.ErrorClasses {
+		r.errorClasses[class] = &errorClass{ec.Count, ec.Examples}
+	}
+	r.targetRate = e.TargetRate
+	r.keyDist = e.KeyDist
+	r.numKeys = e.NumKeys
+	r.partial = e.Partial
+	r.numRetried = e.NumRetried
+	r.numRetries = e.NumRetries
+	r.endpoints = make(map[string]*endpointStats)
+	for name, ee := range e.Endpoints {
+		es := &endpointStats{
+			numOps:    ee.NumOps,
+			numErrors: ee.NumErrors,
+			numBytes:  ee.NumBytes,
+			clients:   make(map[uint]bool),
+			durations: newHistogram(),
+			ttfb:      newHistogram(),
+		}
+		for _, id := range ee.Clients {
+			es.clients[clientBase+id] = true
+		}
+		if ee.Durations != nil {
+			es.durations.merge(ee.Durations)
+		}
+		if ee.Ttfb != nil {
+			es.ttfb.merge(ee.Ttfb)
+		}
+		r.endpoints[name] = es
+	}
+	return r
+}
+
+// Merge result of the same test run by another agent
+func (result *testResult) merge(o testResult) {
+	result.bytesTransmitted += o.bytesTransmitted
+	result.opDurations.merge(o.opDurations)
+	result.opTtfb.merge(o.opTtfb)
+	result.partDurations.merge(o.partDurations)
+	result.partTtfb.merge(o.partTtfb)
+	result.retriedDurations.merge(o.retriedDurations)
+	result.numRetried += o.numRetried
+	result.numRetries += o.numRetries
+	if o.totalDuration > result.totalDuration {
+		result.totalDuration = o.totalDuration
+	}
+	result.opErrors = append(result.opErrors, o.opErrors...)
+	result.numErrors += o.numErrors
+	result.numFailed += o.numFailed
+	for class, oec := range o.errorClasses {
+		ec, ok := result.errorClasses[class]
+		if !ok {
+			result.errorClasses[class] = oec
+			continue
+		}
+		ec.count += oec.count
+		for _, ex := range oec.examples {
+			if len(ec.examples) < maxErrorExamples {
+				ec.examples = append(ec.examples, ex)
+			}
+		}
+	}
+	result.targetRate += o.targetRate
+	result.numKeys += o.numKeys
+	result.partial = result.partial || o.partial
+	for name, oes := range o.endpoints {
+		es, ok := result.endpoints[name]
+		if !ok {
+			result.endpoints[name] = oes
+			continue
+		}
+		es.numOps += oes.numOps
+		es.numErrors += oes.numErrors
+		es.numBytes += oes.numBytes
+		for id := range oes.clients {
+			es.clients[id] = true
+		}
+		es.durations.merge(oes.durations)
+		es.ttfb.merge(oes.ttfb)
+	}
+}
+
+// Send request to the agent and decode JSON response to out
+func agentPost(addr string, path string, in interface{}, out interface{}) error {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	resp, err := http.Post(addr+path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("agent %s: %s", addr, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// Call f for the agents concurrently, returns the first error
+func forAgents(agents []int, f func(i int) error) error {
+	errs := make(chan error, len(agents))
+	for _, i := range agents {
+		go func(i int) {
+			errs <- f(i)
+		}(i)
+	}
+	var ret error
+	for range agents {
+		if err := <-errs; err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+func isCleanupStep(step string) bool {
+	return step == opDelete || step == opBatchDelete || step == stepDeleteBuckets
+}
+
+// Run the tests on the agents in lockstep and merge results of every test
+// of all the agents
+func (params *Params) runController(c Config) ([]testResult, error) {
+	all := make([]int, len(params.agents))
+	for i := range params.agents {
+		all[i] = i
+	}
+	err := forAgents(all, func(i int) error {
+		return agentPost(params.agents[i], "/start", c, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-params.interrupted
+		forAgents(all, func(i int) error {
+			return agentPost(params.agents[i], "/interrupt", nil, nil)
+		})
+	}()
+
+	testResults := []testResult{}
+	steps := make([]agentStep, len(params.agents))
+	pending := all
+	for {
+		// wait for the agents to reach the next step
+		err := forAgents(pending, func(i int) error {
+			return agentPost(params.agents[i], "/step", nil, &steps[i])
+		})
+		if err != nil {
+			return testResults, err
+		}
+		same := true
+		for i, st := range steps {
+			if st.Error != "" {
+				return testResults, fmt.Errorf("agent %s: %s", params.agents[i], st.Error)
+			}
+			same = same && st == steps[0]
+		}
+		if !same {
+			if !params.isInterrupted() {
+				return testResults, fmt.Errorf("agents are at different steps: %v", steps)
+			}
+			// agents which started the next test before the interrupt
+			// run it without requests and go to the cleanup
+			pending = []int{}
+			for i, st := range steps {
+				if !st.Finished && !isCleanupStep(st.Step) {
+					pending = append(pending, i)
+				}
+			}
+			if len(pending) == 0 {
+				return testResults, fmt.Errorf("agents are at different steps: %v", steps)
+			}
+			err = forAgents(pending, func(i int) error {
+				return agentPost(params.agents[i], "/go", nil, nil)
+			})
+			if err != nil {
+				return testResults, err
+			}
+			continue
+		}
+		if steps[0].Finished {
+			return testResults, nil
+		}
+
+		// start the step on all agents at once
+		params.printf("Running %s step on %d agents...\n", steps[0].Step, len(params.agents))
+		exps := make([][]resultExport, len(params.agents))
+		err = forAgents(all, func(i int) error {
+			return agentPost(params.agents[i], "/go", nil, &exps[i])
+		})
+		if err != nil {
+			return testResults, err
+		}
+		for j := range exps[0] {
+			result := exps[0][j].result(0, params.verbose)
+			for i := 1; i < len(exps); i++ {
+				if j >= len(exps[i]) || exps[i][j].Operation != result.operation {
+					return testResults, fmt.Errorf("agent %s returned different results of %s", params.agents[i], steps[0].Step)
+				}
+				result.merge(exps[i][j].result(uint(i)*agentMaxClients, params.verbose))
+			}
+			testResults = append(testResults, result)
+		}
+		pending = all
+	}
+}
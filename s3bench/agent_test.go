@@ -0,0 +1,183 @@
+package s3bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Result of the agent sent to the controller
+func exportImport(t *testing.T, r testResult, clientBase uint) testResult {
+	t.Helper()
+	b, err := json.Marshal(exportResult(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := resultExport{}
+	if err = json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+	return e.result(clientBase, true)
+}
+
+func TestAgentResultMerge(t *testing.T) {
+	all := newResult(opRead)
+	agents := []testResult{newResult(opRead), newResult(opRead)}
+	for i := 0; i < 100; i++ {
+		resp := Resp{
+			top:      opRead,
+			duration: time.Duration(i*i) * time.Millisecond,
+			ttfb:     time.Duration(i) * time.Millisecond,
+			numBytes: int64(i * 1000),
+			endpoint: []string{"http://a", "http://b"}[i%3%2],
+			clientId: uint(i % 4),
+		}
+		if i%10 == 0 {
+			resp.err = errors.New("failed")
+		}
+		if i%7 == 0 {
+			resp.retries = int64(i % 3)
+		}
+		a := i % 2
+		agents[a].add(resp, uint(i))
+		// clients of the second agent are numbered by the controller
+		resp.clientId += uint(a) * agentMaxClients
+		all.add(resp, uint(i))
+	}
+
+	merged := exportImport(t, agents[0], 0)
+	merged.merge(exportImport(t, agents[1], agentMaxClients))
+
+	if merged.numRequests() != all.numRequests() || merged.numFailed != all.numFailed ||
+		merged.bytesTransmitted != all.bytesTransmitted || merged.numRetried != all.numRetried ||
+		merged.numRetries != all.numRetries || merged.numErrors != all.numErrors {
+		t.Errorf("merged counts %+v, expected %+v", merged, all)
+	}
+	for name, hh := range map[string][2]*Histogram{
+		"durations":         {merged.opDurations, all.opDurations},
+		"ttfb":              {merged.opTtfb, all.opTtfb},
+		"retried durations": {merged.retriedDurations, all.retriedDurations},
+	} {
+		if !reflect.DeepEqual(hh[0], hh[1]) {
+			t.Errorf("merged %s %+v, expected %+v", name, hh[0], hh[1])
+		}
+	}
+	if merged.errorClasses["Other"].count != all.errorClasses["Other"].count {
+		t.Errorf("merged error classes %v, expected %v", merged.errorsReport(), all.errorsReport())
+	}
+	for name, es := range all.endpoints {
+		mes := merged.endpoints[name]
+		if mes == nil || mes.numOps != es.numOps || mes.numErrors != es.numErrors || mes.numBytes != es.numBytes ||
+			!reflect.DeepEqual(mes.clients, es.clients) || !reflect.DeepEqual(mes.durations, es.durations) {
+			t.Errorf("merged endpoint %s %+v, expected %+v", name, mes, es)
+		}
+	}
+}
+
+// Agent process started by TestAgents
+func TestAgentProcess(t *testing.T) {
+	addr := os.Getenv("S3BENCH_TEST_AGENT")
+	if addr == "" {
+		t.Skip("started by TestAgents")
+	}
+	t.Fatal(ServeAgent(addr))
+}
+
+// Start the agent in a subprocess, sample data is global so agents cannot
+// run in the same process
+func startAgent(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestAgentProcess$")
+	cmd.Env = append(os.Environ(), "S3BENCH_TEST_AGENT="+addr)
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("agent is not listening on %s: %v", addr, err)
+		}
+	}
+}
+
+func TestAgents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts agent processes")
+	}
+	s := newS3Stub(0, 0, 0)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c := DefaultConfig()
+	c.AccessKey = "key"
+	c.AccessSecret = "secret"
+	c.Endpoints = []string{srv.URL}
+	c.ObjectSize = "16Kb"
+	c.NumClients = 2
+	c.NumSamples = 10
+	c.ClientDelay = 0
+	c.HeadObj = true
+	c.Agents = []string{startAgent(t), startAgent(t)}
+	runner, err := NewRunner(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []string{opWrite, opHeadObj, opRead, opBatchDelete}
+	if len(results) != len(ops) {
+		t.Fatalf("expected %d results, got %d", len(ops), len(results))
+	}
+	for i, r := range results {
+		// every agent writes and reads its own objects
+		numReqs := int64(len(c.Agents) * c.NumSamples)
+		if r.Operation == opBatchDelete {
+			numReqs = int64(len(c.Agents))
+			if r.Keys != int64(len(c.Agents)*c.NumSamples) {
+				t.Errorf("%s: %d keys deleted", r.Operation, r.Keys)
+			}
+		}
+		if r.Operation != ops[i] || r.Requests != numReqs || r.Errors > 0 {
+			t.Errorf("result %d: %s with %d requests and %d errors, expected %s with %d requests",
+				i, r.Operation, r.Requests, r.Errors, ops[i], numReqs)
+		}
+		if n := r.result.opDurations.count(); n != numReqs {
+			t.Errorf("%s: %d durations recorded", r.Operation, n)
+		}
+		es := r.result.endpoints[srv.URL]
+		if es == nil || es.numOps != numReqs {
+			t.Errorf("%s: endpoint stats %+v", r.Operation, es)
+		} else if r.Operation != opBatchDelete && len(es.clients) != len(c.Agents)*c.NumClients {
+			t.Errorf("%s: %d clients", r.Operation, len(es.clients))
+		}
+	}
+	if r := results[0]; r.Bytes != int64(len(c.Agents)*c.NumSamples*16*1024) {
+		t.Errorf("%s: %d bytes written", r.Operation, r.Bytes)
+	}
+	checkCleanedUp(t, s)
+}
@@ -0,0 +1,306 @@
+package s3bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Config of the tests. Sizes are given like "64Kb", empty size means the
+// feature is off. The controller pushes the config to agents as JSON
+type Config struct {
+	AccessKey        string
+	AccessSecret     string
+	Region           string
+	Endpoints        []string
+	Bucket           string
+	ObjectNamePrefix string
+	ObjectSize       string // fixed size or distribution, see parseSizeDist
+	SizeSeed         int64
+	NumClients       int
+	NumSamples       int
+	SampleReads      int
+	ClientDelay      int // ms, randomized up to the absolute value if negative
+	Verbose          bool
+	SkipCleanup      bool
+	SkipWrite        bool
+	SkipRead         bool
+	ReadObj          bool
+	HeadObj          bool
+	PutObjTag        bool
+	GetObjTag        bool
+	NumTags          int
+	TagNamePrefix    string
+	TagValPrefix     string
+	Validate         bool
+	DeleteAtOnce     int
+	DeleteMode       string
+	PartSize         string
+	PartConcurrency  int
+	RangeSize        string
+	RangeOffset      string
+	RangePattern     string
+	Mix              string
+	Duration         time.Duration
+	Interval         time.Duration
+	Rate             float64
+	RateDist         string
+	KeyDist          string
+	CopyObj          bool
+	CopyBucket       string
+	CopyPartSize     string
+	ListObj          bool
+	ListSamples      int
+	ListMaxKeys      int
+	ListDelimiter    string
+	ListStartAfter   string
+	Ops              string        // comma separated operations run one by one
+	RequestTimeout   time.Duration // timeout of every HTTP request, 0 means no timeout
+	MaxRetries       int           // retries of failed HTTP requests
+	RetryMinDelay    time.Duration // backoff of retries, 0 means SDK default
+	RetryMaxDelay    time.Duration
+	ScenarioFile     string
+	Scenario         json.RawMessage `json:",omitempty"` // content of ScenarioFile, read from the file if empty
+
+	// not pushed to agents
+	Agents      []string        `json:"-"` // run the tests on the agents
+	TraceFile   string          `json:"-"`
+	TraceFormat string          `json:"-"`
+	MetricsAddr string          `json:"-"` // serve Prometheus metrics on the address
+	OnRequest   func(r Request) `json:"-"` // called for every completed request
+}
+
+// Config with the defaults of the command line flags
+func DefaultConfig() Config {
+	return Config{
+		Region:           "igneous-test",
+		Bucket:           "bucketname",
+		ObjectNamePrefix: "loadgen_test",
+		ObjectSize:       "80Mb",
+		SizeSeed:         1,
+		NumClients:       40,
+		NumSamples:       200,
+		SampleReads:      1,
+		ClientDelay:      1,
+		ReadObj:          true,
+		NumTags:          10,
+		TagNamePrefix:    "tag_name_",
+		TagValPrefix:     "tag_val_",
+		DeleteAtOnce:     1000,
+		DeleteMode:       deleteBatch,
+		PartConcurrency:  1,
+		RangeOffset:      "0b",
+		RangePattern:     rangeRandom,
+		RateDist:         rateConstant,
+		KeyDist:          keySequential,
+		ListSamples:      1,
+		ListMaxKeys:      1000,
+		MaxRetries:       client.DefaultRetryerMaxNumRetries,
+		TraceFormat:      traceCSV,
+	}
+}
+
+func (c Config) awsConfig() *aws.Config {
+	cfg := &aws.Config{
+		Credentials:      credentials.NewStaticCredentials(c.AccessKey, c.AccessSecret, ""),
+		Region:           aws.String(c.Region),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(c.MaxRetries),
+	}
+	if c.RequestTimeout > 0 {
+		// covers reading of the response body too
+		cfg.HTTPClient = &http.Client{Timeout: c.RequestTimeout}
+	}
+	return request.WithRetryer(cfg, client.DefaultRetryer{
+		NumMaxRetries:    c.MaxRetries,
+		MinRetryDelay:    c.RetryMinDelay,
+		MaxRetryDelay:    c.RetryMaxDelay,
+		MinThrottleDelay: c.RetryMinDelay,
+		MaxThrottleDelay: c.RetryMaxDelay,
+	})
+}
+
+// size of the config, 0 if not set
+func configSize(name, sz string) (int64, error) {
+	if sz == "" {
+		return 0, nil
+	}
+	ret, err := parseSizeErr(sz)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return ret, nil
+}
+
+// Validate the config, returns Params and scenario phases of the tests
+func (c Config) params() (*Params, []scenarioPhase, error) {
+	if len(c.Endpoints) == 0 {
+		return nil, nil, fmt.Errorf("no endpoints")
+	}
+	if c.NumClients < 1 || c.NumClients > c.NumSamples {
+		return nil, nil, fmt.Errorf("numClients(%d) needs to be less than numSamples(%d) and greater than 0", c.NumClients, c.NumSamples)
+	}
+	if c.SampleReads < 1 {
+		return nil, nil, fmt.Errorf("sampleReads cannot be less than 1")
+	}
+	if c.DeleteAtOnce < 1 {
+		return nil, nil, fmt.Errorf("cannot delete less than 1 obj at once")
+	}
+	if c.DeleteMode != deleteSingle && c.DeleteMode != deleteBatch {
+		return nil, nil, fmt.Errorf("unknown deleteMode %s", c.DeleteMode)
+	}
+	if c.NumTags < 1 {
+		return nil, nil, fmt.Errorf("numTags cannot be less than 1")
+	}
+	if c.PartConcurrency < 1 {
+		return nil, nil, fmt.Errorf("partConcurrency cannot be less than 1")
+	}
+	if c.ListObj && (c.ListSamples < 1 || c.ListMaxKeys < 1) {
+		return nil, nil, fmt.Errorf("listSamples and listMaxKeys cannot be less than 1")
+	}
+	if c.Rate < 0 {
+		return nil, nil, fmt.Errorf("rate cannot be negative")
+	}
+	if c.RateDist != rateConstant && c.RateDist != ratePoisson {
+		return nil, nil, fmt.Errorf("unknown rateDist %s", c.RateDist)
+	}
+	if c.RequestTimeout < 0 || c.MaxRetries < 0 || c.RetryMinDelay < 0 || c.RetryMaxDelay < 0 {
+		return nil, nil, fmt.Errorf("requestTimeout, maxRetries and retry delays cannot be negative")
+	}
+	if c.RetryMaxDelay > 0 && c.RetryMinDelay > c.RetryMaxDelay {
+		return nil, nil, fmt.Errorf("retryMinDelay cannot be greater than retryMaxDelay")
+	}
+	if len(c.Agents) > 0 && (c.TraceFile != "" || c.MetricsAddr != "" || c.OnRequest != nil) {
+		return nil, nil, fmt.Errorf("trace, metrics and OnRequest are not supported with agents")
+	}
+
+	dist, err := parseSizeDist(c.ObjectSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid objectSize: %v", err)
+	}
+	params := &Params{
+		requests:         make(chan Req),
+		responses:        make(chan Resp),
+		interrupted:      make(chan struct{}),
+		numSamples:       uint(c.NumSamples),
+		numClients:       uint(c.NumClients),
+		objectSize:       dist.max,
+		sizes:            newKeySizes(dist, c.SizeSeed),
+		objectNamePrefix: c.ObjectNamePrefix,
+		bucketName:       c.Bucket,
+		endpoints:        c.Endpoints,
+		verbose:          c.Verbose,
+		headObj:          c.HeadObj,
+		sampleReads:      uint(c.SampleReads),
+		clientDelay:      c.ClientDelay,
+		deleteAtOnce:     c.DeleteAtOnce,
+		deleteMode:       c.DeleteMode,
+		putObjTag:        c.PutObjTag,
+		getObjTag:        c.GetObjTag,
+		numTags:          uint(c.NumTags),
+		readObj:          c.ReadObj,
+		tagNamePrefix:    c.TagNamePrefix,
+		tagValPrefix:     c.TagValPrefix,
+		validate:         c.Validate,
+		skipWrite:        c.SkipWrite,
+		skipRead:         c.SkipRead,
+		partConcurrency:  uint(c.PartConcurrency),
+		rangePattern:     c.RangePattern,
+		duration:         c.Duration,
+		interval:         c.Interval,
+		rate:             c.Rate,
+		rateDist:         c.RateDist,
+		copyObj:          c.CopyObj,
+		copyBucket:       c.CopyBucket,
+		listObj:          c.ListObj,
+		listSamples:      uint(c.ListSamples),
+		listMaxKeys:      int64(c.ListMaxKeys),
+		listDelimiter:    c.ListDelimiter,
+		listStartAfter:   c.ListStartAfter,
+		agents:           c.Agents,
+		onRequest:        c.OnRequest,
+		requestTimeout:   c.RequestTimeout,
+		maxRetries:       c.MaxRetries,
+		retryMinDelay:    c.RetryMinDelay,
+		retryMaxDelay:    c.RetryMaxDelay,
+	}
+
+	if params.partSize, err = configSize("partSize", c.PartSize); err != nil {
+		return nil, nil, err
+	}
+	if params.copyPartSize, err = configSize("copyPartSize", c.CopyPartSize); err != nil {
+		return nil, nil, err
+	}
+	if params.rangeSize, err = configSize("rangeSize", c.RangeSize); err != nil {
+		return nil, nil, err
+	}
+	if c.RangeSize != "" {
+		if params.rangeSize < 1 {
+			return nil, nil, fmt.Errorf("rangeSize cannot be less than 1b")
+		}
+		if params.rangeOffset, err = configSize("rangeOffset", c.RangeOffset); err != nil {
+			return nil, nil, err
+		}
+		if params.rangePattern != rangeFixed && params.rangePattern != rangeRandom &&
+			params.rangePattern != rangeSequential {
+			return nil, nil, fmt.Errorf("unknown rangePattern %s", params.rangePattern)
+		}
+		if params.rangeOffset >= dist.min {
+			return nil, nil, fmt.Errorf("rangeOffset should be less than the smallest objectSize")
+		}
+	}
+
+	if params.keys, err = parseKeyDist(c.KeyDist); err != nil {
+		return nil, nil, fmt.Errorf("invalid keyDist: %v", err)
+	}
+
+	if c.Mix != "" {
+		if params.mix, err = params.parseMix(c.Mix); err != nil {
+			return nil, nil, fmt.Errorf("invalid mix: %v", err)
+		}
+		for _, m := range params.mix {
+			if params.skipWrite && findOperation(m.op).WritesObjects() {
+				return nil, nil, fmt.Errorf("mix cannot contain write operations with skipWrite")
+			}
+		}
+	}
+
+	numModes := 0
+	for _, set := range []bool{c.Mix != "", c.Ops != "", c.ScenarioFile != "" || len(c.Scenario) > 0} {
+		if set {
+			numModes++
+		}
+	}
+	if numModes > 1 {
+		return nil, nil, fmt.Errorf("only one of mix, ops and scenario can be set")
+	}
+	var phases []scenarioPhase
+	if c.Ops != "" {
+		params.ops = c.Ops
+		if phases, err = params.parseOps(c.Ops); err != nil {
+			return nil, nil, fmt.Errorf("invalid ops: %v", err)
+		}
+	}
+	if c.ScenarioFile != "" || len(c.Scenario) > 0 {
+		params.scenario = c.ScenarioFile
+		if len(c.Scenario) > 0 {
+			phases, err = params.parseScenario(c.Scenario)
+		} else {
+			phases, err = params.loadScenario(c.ScenarioFile)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid scenario: %v", err)
+		}
+	}
+
+	if params.skipWrite && params.validate && dist.kind != sizeFixed {
+		return nil, nil, fmt.Errorf("validate of objects with random sizes requires Write test")
+	}
+	return params, phases, nil
+}
@@ -0,0 +1,48 @@
+package s3bench
+
+import (
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// suffix of the object name prefix of copies
+const copySuffix = "_copy"
+
+// bucket where the objects are copied to
+func (params Params) copyDstBucket() string {
+	if params.copyBucket != "" {
+		return params.copyBucket
+	}
+	return params.bucketName
+}
+
+// source of the object copy
+func copySource(bucket string, key string) *string {
+	return aws.String(bucket + "/" + url.PathEscape(key))
+}
+
+// Delete copies of the objects
+func (params *Params) deleteCopies(svc *s3.S3) {
+	numDeleted := 0
+	keyList := make([]*s3.ObjectIdentifier, 0, params.deleteAtOnce)
+	for i := uint(0); i < params.numSamples; i++ {
+		keyList = append(keyList, &s3.ObjectIdentifier{
+			Key: genObjName(params.objectNamePrefix+copySuffix, data_hash_base32, i),
+		})
+		if len(keyList) == params.deleteAtOnce || i == params.numSamples-1 {
+			result, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket: aws.String(params.copyDstBucket()),
+				Delete: &s3.Delete{Objects: keyList},
+			})
+			if err == nil {
+				numDeleted += len(result.Deleted)
+			} else {
+				params.printf("Deleting a batch of copies failed (%v)\n", err)
+			}
+			keyList = keyList[:0]
+		}
+	}
+	params.printf("Deleted %d/%d copies\n", numDeleted, params.numSamples)
+}
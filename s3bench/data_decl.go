@@ -0,0 +1,162 @@
+package s3bench
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	opRead  = "Read"
+	opWrite = "Write"
+	opHeadObj = "HeadObj"
+	opGetObjTag = "GetObjTag"
+	opPutObjTag = "PutObjTag"
+	opValidate = "Validate"
+	opMpWrite = "MpWrite"
+	opRangedRead = "RangedRead"
+	opMix = "Mix"
+	opList = "List"
+	opDelete = "Delete"
+	opBatchDelete = "BatchDelete"
+	opCopy = "Copy"
+)
+
+const (
+	deleteSingle = "single"
+	deleteBatch  = "batch"
+)
+
+const (
+	rateConstant = "constant"
+	ratePoisson  = "poisson"
+)
+
+const (
+	rangeFixed      = "fixed"
+	rangeRandom     = "random"
+	rangeSequential = "sequential"
+)
+
+type Req struct {
+	top   string
+	req   interface{}
+	size  int64     // expected length of the object data
+	sched time.Time // intended send time of rate limited request
+}
+
+type Resp struct {
+	top           string
+	err           error
+	duration      time.Duration
+	numBytes      int64
+	ttfb          time.Duration
+	numKeys       int64           // listed or deleted keys
+	keyErrors     []error         // failed keys of batch delete
+	key           string
+	endpoint      string          // endpoint of the client which sent the request
+	clientId      uint
+	start         time.Time
+	status        int             // HTTP status
+	partDurations []time.Duration // parts of multipart upload or pages of listing
+	partTtfb      []time.Duration
+	retries       int64           // retries of all the requests sent by the operation
+}
+
+// Operation of the mixed workload and its relative weight
+type mixOp struct {
+	op     string
+	weight uint
+}
+
+// Specifies the parameters for a given test
+type Params struct {
+	requests         chan Req
+	responses        chan Resp
+	clients          *sync.WaitGroup // started clients
+	numSamples       uint
+	numClients       uint
+	objectSize       int64 // the largest object size
+	sizes            *keySizes
+	objectNamePrefix string
+	bucketName       string
+	endpoints        []string
+	verbose          bool
+	headObj          bool
+	sampleReads      uint
+	clientDelay      int
+	deleteAtOnce     int
+	deleteMode       string
+	putObjTag        bool
+	getObjTag        bool
+	numTags          uint
+	readObj          bool
+	tagNamePrefix    string
+	tagValPrefix     string
+	validate         bool
+	skipWrite        bool
+	skipRead         bool
+	partSize         int64
+	partConcurrency  uint
+	rangeSize        int64
+	rangeOffset      int64
+	rangePattern     string
+	mix              []mixOp
+	duration         time.Duration
+	interval         time.Duration
+	rate             float64
+	rateDist         string
+	keys             *keyDist
+	trace            *traceWriter
+	metrics          *metrics
+	interrupted      chan struct{} // closed on SIGINT/SIGTERM
+	keyStart         uint // index of the first object
+	scenario         string
+	ops              string // operations run one by one
+	phase            string // name of the running scenario phase
+	ownSizes         bool // objectSize is set by the phase
+	written          *writtenKeys // sizes of the objects written by the phases
+	test             string // running test, the operation or opMix
+	agent            *agentRun
+	agents           []string // addresses of agents run by the controller
+	onRequest        func(r Request)
+	requestTimeout   time.Duration
+	maxRetries       int
+	retryMinDelay    time.Duration
+	retryMaxDelay    time.Duration
+	copyObj          bool
+	copyBucket       string
+	copyPartSize     int64
+	listObj          bool
+	listSamples      uint
+	listMaxKeys      int64
+	listDelimiter    string
+	listStartAfter   string
+}
+
+// Contains the summary for a given test result
+type testResult struct {
+	operation        string
+	bytesTransmitted int64
+	opDurations      *Histogram
+	totalDuration    time.Duration
+	opTtfb           *Histogram
+	opErrors         []string // all errors, kept in verbose mode only
+	keepErrors       bool
+	numErrors        int64
+	numFailed        int64 // failed requests
+	errorClasses     map[string]*errorClass
+	partDurations    *Histogram
+	partTtfb         *Histogram
+	targetRate       float64
+	keyDist          string
+	numKeys          int64
+	window           *timelineWindow
+	timeline         []timelineWindow
+	endpoints        map[string]*endpointStats
+	partial          bool // interrupted before all requests were sent
+	name             string // scenario phase
+	numRetried       int64 // requests with retries, both successful and failed
+	numRetries       int64
+	retriedDurations *Histogram // successful requests with retries
+	traceDropped     int64 // requests not written to the trace file
+}
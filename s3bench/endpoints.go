@@ -0,0 +1,77 @@
+package s3bench
+
+import (
+	"sort"
+)
+
+// Stats of requests served by an endpoint
+type endpointStats struct {
+	numOps    int64
+	numErrors int64
+	numBytes  int64
+	clients   map[uint]bool
+	durations *Histogram
+	ttfb      *Histogram
+}
+
+func (es *endpointStats) add(resp Resp) {
+	es.numOps++
+	es.clients[resp.clientId] = true
+	if resp.err != nil {
+		es.numErrors++
+		return
+	}
+	es.numErrors += int64(len(resp.keyErrors))
+	es.numBytes += resp.numBytes
+	es.durations.record(resp.duration)
+	es.ttfb.record(resp.ttfb)
+}
+
+// Add stats of the completed request to its endpoint stats
+func (result *testResult) addEndpoint(resp Resp) {
+	if result.endpoints == nil {
+		result.endpoints = make(map[string]*endpointStats)
+	}
+	es, ok := result.endpoints[resp.endpoint]
+	if !ok {
+		es = &endpointStats{
+			clients:   make(map[uint]bool),
+			durations: newHistogram(),
+			ttfb:      newHistogram(),
+		}
+		result.endpoints[resp.endpoint] = es
+	}
+	es.add(resp)
+}
+
+// Per endpoint section of the result report
+func (result testResult) endpointsReport() []map[string]interface{} {
+	names := make([]string, 0, len(result.endpoints))
+	for name := range result.endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		es := result.endpoints[name]
+		rep := make(map[string]interface{})
+		rep["Endpoint"] = name
+		rep["Clients Count"] = len(es.clients)
+		rep["Total Requests Count"] = es.numOps
+		rep["Errors Count"] = es.numErrors
+		if result.transfersData() {
+			rep["Total Transferred (MB)"] = float64(es.numBytes) / (1024 * 1024)
+		}
+		if result.totalDuration > 0 {
+			if result.transfersData() {
+				rep["Total Throughput (MB/s)"] = float64(es.numBytes) / (1024 * 1024) / result.totalDuration.Seconds()
+			}
+			rep["Rate (ops/s)"] = float64(es.numOps) / result.totalDuration.Seconds()
+		}
+		statsReport(rep, "Duration", es.durations)
+		statsReport(rep, "Ttfb", es.ttfb)
+		ret = append(ret, rep)
+	}
+	return ret
+}
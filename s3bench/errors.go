@@ -0,0 +1,126 @@
+package s3bench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// max number of example messages kept per error class
+const maxErrorExamples = 5
+
+// Data validation failure: unexpected length or checksum
+type validationError struct {
+	msg string
+}
+
+func (e validationError) Error() string {
+	return e.msg
+}
+
+func validationErrorf(format string, args ...interface{}) error {
+	return validationError{fmt.Sprintf(format, args...)}
+}
+
+// Failure of a key of multi-object request
+type keyError struct {
+	key     string
+	code    string
+	message string
+}
+
+func (e keyError) Error() string {
+	return fmt.Sprintf("key %s failed with error %s: %s", e.key, e.code, e.message)
+}
+
+// Errors of the same class
+type errorClass struct {
+	count    int64
+	examples []string
+}
+
+// Class of the error: S3 error code and HTTP status, network failure or
+// timeout, validation failure
+func classifyError(err error) string {
+	var verr validationError
+	var kerr keyError
+	var rerr awserr.RequestFailure
+	var aerr awserr.Error
+	switch {
+	case errors.As(err, &verr):
+		return "Validation"
+	case errors.As(err, &kerr):
+		return "S3 " + kerr.code
+	case errors.As(err, &rerr):
+		return fmt.Sprintf("S3 %s (HTTP %d)", rerr.Code(), rerr.StatusCode())
+	case errors.As(err, &aerr):
+		if isTimeout(aerr.OrigErr()) {
+			return "Network timeout"
+		}
+		if aerr.Code() == "RequestError" || isNetwork(aerr.OrigErr()) {
+			return "Network"
+		}
+		return "SDK " + aerr.Code()
+	case isTimeout(err):
+		return "Network timeout"
+	case isNetwork(err):
+		// not wrapped by the SDK, eg reading of the response body
+		return "Network"
+	}
+	return "Other"
+}
+
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return err != nil && errors.As(err, &nerr) && nerr.Timeout()
+}
+
+func isNetwork(err error) bool {
+	var nerr net.Error
+	return err != nil && (errors.As(err, &nerr) || errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+// Count the error in its class, message is kept as example of the class
+// and, with keepErrors, in the full list of errors
+func (result *testResult) addError(err error, msg string) {
+	result.numErrors++
+	if result.keepErrors {
+		result.opErrors = append(result.opErrors, msg)
+	}
+	if result.errorClasses == nil {
+		result.errorClasses = make(map[string]*errorClass)
+	}
+	class := classifyError(err)
+	ec, ok := result.errorClasses[class]
+	if !ok {
+		ec = &errorClass{}
+		result.errorClasses[class] = ec
+	}
+	ec.count++
+	if len(ec.examples) < maxErrorExamples {
+		ec.examples = append(ec.examples, msg)
+	}
+}
+
+// Error classes section of the result report
+func (result testResult) errorsReport() map[string]interface{} {
+	classes := make([]string, 0, len(result.errorClasses))
+	for class := range result.errorClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	ret := make(map[string]interface{})
+	for _, class := range classes {
+		ec := result.errorClasses[class]
+		ret[class] = map[string]interface{}{
+			"Count":    ec.count,
+			"Examples": ec.examples,
+		}
+	}
+	return ret
+}
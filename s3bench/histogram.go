@@ -0,0 +1,214 @@
+package s3bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Values below 2^histSubBucketBits us are recorded exactly, larger ones with
+// relative error below 2^-(histSubBucketBits-1)
+const histSubBucketBits = 8
+
+const (
+	histSubBucketCount = 1 << histSubBucketBits
+	histSubBucketHalf  = histSubBucketCount / 2
+)
+
+// High dynamic range histogram of durations with microsecond resolution.
+// Memory does not depend on the number of recorded values, histograms can
+// be merged and saved to / loaded from JSON
+type Histogram struct {
+	Total  int64   `json:"total"`
+	Min    int64   `json:"min"` // us
+	Max    int64   `json:"max"` // us
+	Sum    float64 `json:"sum"` // us
+	Counts []int64 `json:"counts"`
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{}
+}
+
+func histIndex(v int64) int {
+	if v < histSubBucketCount {
+		return int(v)
+	}
+	exp := bits.Len64(uint64(v)) - histSubBucketBits
+	sub := int(v >> uint(exp))
+	return histSubBucketCount + (exp-1)*histSubBucketHalf + sub - histSubBucketHalf
+}
+
+// the highest value recorded to the bucket idx
+func histValue(idx int) int64 {
+	if idx < histSubBucketCount {
+		return int64(idx)
+	}
+	exp := (idx-histSubBucketCount)/histSubBucketHalf + 1
+	sub := int64((idx-histSubBucketCount)%histSubBucketHalf + histSubBucketHalf)
+	return (sub+1)<<uint(exp) - 1
+}
+
+func (h *Histogram) record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+	idx := histIndex(v)
+	if idx >= len(h.Counts) {
+		h.Counts = append(h.Counts, make([]int64, idx+1-len(h.Counts))...)
+	}
+	h.Counts[idx]++
+	if h.Total == 0 || v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Total++
+	h.Sum += float64(v)
+}
+
+func (h *Histogram) merge(o *Histogram) {
+	if o.Total == 0 {
+		return
+	}
+	if len(o.Counts) > len(h.Counts) {
+		h.Counts = append(h.Counts, make([]int64, len(o.Counts)-len(h.Counts))...)
+	}
+	for i, c := range o.Counts {
+		h.Counts[i] += c
+	}
+	if h.Total == 0 || o.Min < h.Min {
+		h.Min = o.Min
+	}
+	if o.Max > h.Max {
+		h.Max = o.Max
+	}
+	h.Total += o.Total
+	h.Sum += o.Sum
+}
+
+func (h *Histogram) count() int64 {
+	return h.Total
+}
+
+// p-th percentile in seconds, p in range [0..100]
+func (h *Histogram) percentile(p float64) float64 {
+	if h.Total == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return float64(h.Min) / 1e6
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.Total)))
+	if rank >= h.Total {
+		return float64(h.Max) / 1e6
+	}
+	var seen int64
+	for idx, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			v := histValue(idx)
+			if v > h.Max {
+				v = h.Max
+			} else if v < h.Min {
+				v = h.Min
+			}
+			return float64(v) / 1e6
+		}
+	}
+	return float64(h.Max) / 1e6
+}
+
+// average in seconds
+func (h *Histogram) avg() float64 {
+	if h.Total == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Total) / 1e6
+}
+
+// Histograms of a test saved to file
+type histogramsExport struct {
+	Operation     string     `json:"operation"`
+	Name          string     `json:"name,omitempty"` // scenario phase
+	Duration      *Histogram `json:"duration"`
+	Ttfb          *Histogram `json:"ttfb"`
+	PartDurations *Histogram `json:"partDuration"`
+	PartTtfb      *Histogram `json:"partTtfb"`
+	Retried       *Histogram `json:"retriedDuration"`
+}
+
+// Save histograms of the tests to JSON file
+func SaveHistograms(fileName string, tests []Result) error {
+	exp := make([]histogramsExport, 0, len(tests))
+	for _, t := range tests {
+		r := t.result
+		exp = append(exp, histogramsExport{r.operation, r.name, r.opDurations, r.opTtfb, r.partDurations, r.partTtfb, r.retriedDurations})
+	}
+	b, err := json.Marshal(exp)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, b, 0644)
+}
+
+// Test of a histograms file: scenario phase, operation and number of the
+// earlier tests of the file with the same phase and operation, eg the
+// Write of -mix after the Write test
+type histogramsKey struct {
+	name string
+	op   string
+	n    int
+}
+
+// Load histograms saved by SaveHistograms from files and merge them by
+// phase and operation, repeated tests are merged in order
+func MergeHistogramFiles(fileNames []string) ([]Result, error) {
+	ret := []testResult{}
+	index := make(map[histogramsKey]int)
+	for _, fn := range fileNames {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		exp := []histogramsExport{}
+		if err = json.Unmarshal(b, &exp); err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		seen := make(map[histogramsKey]int)
+		for _, e := range exp {
+			k := histogramsKey{name: e.Name, op: e.Operation}
+			k.n = seen[k]
+			seen[k]++
+			ri, ok := index[k]
+			if !ok {
+				r := newResult(e.Operation)
+				r.name = e.Name
+				ret = append(ret, r)
+				ri = len(ret) - 1
+				index[k] = ri
+			}
+			for _, hh := range [][2]*Histogram{
+				{ret[ri].opDurations, e.Duration},
+				{ret[ri].opTtfb, e.Ttfb},
+				{ret[ri].partDurations, e.PartDurations},
+				{ret[ri].partTtfb, e.PartTtfb},
+				{ret[ri].retriedDurations, e.Retried},
+			} {
+				if hh[1] != nil {
+					hh[0].merge(hh[1])
+				}
+			}
+		}
+	}
+	results := make([]Result, 0, len(ret))
+	for _, r := range ret {
+		results = append(results, newPublicResult(r))
+	}
+	return results, nil
+}
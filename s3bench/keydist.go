@@ -0,0 +1,111 @@
+package s3bench
+
+import (
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keySequential = "sequential"
+	keyUniform    = "uniform"
+	keyZipf       = "zipf"
+	keyHotspot    = "hotspot"
+)
+
+// Distribution of keys accessed by read tests
+type keyDist struct {
+	mtx        sync.Mutex
+	kind       string
+	spec       string
+	skew       float64 // zipf
+	hotTraffic float64 // hotspot, share of requests
+	hotKeys    float64 // hotspot, share of keys
+	rnd        *mathrand.Rand
+	zipf       *mathrand.Zipf
+	zipfKeys   uint
+}
+
+// parse key distribution specification, one of:
+//
+//	sequential
+//	uniform
+//	zipf:1.1
+//	hotspot:80,20 - 80% of requests access 20% of keys
+func parseKeyDist(spec string) (*keyDist, error) {
+	kd := &keyDist{spec: spec, rnd: mathrand.New(mathrand.NewSource(time.Now().UnixNano()))}
+	args := ""
+	kd.kind = spec
+	if ci := strings.Index(spec, ":"); ci >= 0 {
+		kd.kind, args = spec[:ci], spec[ci+1:]
+	}
+	switch kd.kind {
+	case keySequential, keyUniform:
+		if args != "" {
+			return nil, fmt.Errorf("%s has no arguments", kd.kind)
+		}
+	case keyZipf:
+		kd.skew = 1.1
+		if args != "" {
+			skew, err := strconv.ParseFloat(args, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid skew: %v", err)
+			}
+			kd.skew = skew
+		}
+		if kd.skew <= 1 {
+			return nil, fmt.Errorf("zipf skew should be greater than 1")
+		}
+	case keyHotspot:
+		xy := strings.Split(args, ",")
+		if len(xy) != 2 {
+			return nil, fmt.Errorf("expected %s:trafficPercent,keysPercent", keyHotspot)
+		}
+		var err error
+		if kd.hotTraffic, err = strconv.ParseFloat(xy[0], 64); err != nil {
+			return nil, fmt.Errorf("invalid traffic percent: %v", err)
+		}
+		if kd.hotKeys, err = strconv.ParseFloat(xy[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid keys percent: %v", err)
+		}
+		if kd.hotTraffic < 0 || kd.hotTraffic > 100 || kd.hotKeys <= 0 || kd.hotKeys > 100 {
+			return nil, fmt.Errorf("percents should be in range (0..100]")
+		}
+		kd.hotTraffic /= 100
+		kd.hotKeys /= 100
+	default:
+		return nil, fmt.Errorf("unknown distribution %q", kd.kind)
+	}
+	return kd, nil
+}
+
+// index of the key accessed by i-th request out of numKeys keys
+func (kd *keyDist) next(i uint, numKeys uint) uint {
+	if kd.kind == keySequential || numKeys < 2 {
+		return i % numKeys
+	}
+
+	kd.mtx.Lock()
+	defer kd.mtx.Unlock()
+	switch kd.kind {
+	case keyUniform:
+		return uint(kd.rnd.Int63n(int64(numKeys)))
+	case keyZipf:
+		if kd.zipf == nil || kd.zipfKeys != numKeys {
+			kd.zipf = mathrand.NewZipf(kd.rnd, kd.skew, 1, uint64(numKeys-1))
+			kd.zipfKeys = numKeys
+		}
+		return uint(kd.zipf.Uint64())
+	case keyHotspot:
+		hot := uint(math.Ceil(float64(numKeys) * kd.hotKeys))
+		if hot >= numKeys || kd.rnd.Float64() < kd.hotTraffic {
+			return uint(kd.rnd.Int63n(int64(hot)))
+		}
+		return hot + uint(kd.rnd.Int63n(int64(numKeys-hot)))
+	}
+	panic("Developer error")
+}
@@ -0,0 +1,31 @@
+package s3bench
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// List all pages of the request. Returns number of listed keys and common
+// prefixes, per-page durations and ttfb
+func (params *Params) listObjects(svc *s3.S3, r *s3.ListObjectsV2Input) (int64, []time.Duration, []time.Duration, error) {
+	var numKeys int64
+	pageDurations := []time.Duration{}
+	pageTtfb := []time.Duration{}
+	for {
+		pageStartTime := time.Now()
+		req, resp := svc.ListObjectsV2Request(r)
+		ttfb, err := sendTtfb(req)
+		pageTtfb = append(pageTtfb, ttfb)
+		pageDurations = append(pageDurations, time.Since(pageStartTime))
+		if err != nil {
+			return numKeys, pageDurations, pageTtfb, err
+		}
+		numKeys += int64(len(resp.Contents) + len(resp.CommonPrefixes))
+		if !aws.BoolValue(resp.IsTruncated) {
+			return numKeys, pageDurations, pageTtfb, nil
+		}
+		r.ContinuationToken = resp.NextContinuationToken
+	}
+}
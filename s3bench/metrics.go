@@ -0,0 +1,188 @@
+package s3bench
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// upper bounds (seconds) of latency histogram buckets
+var metricsBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type metricsSeries struct {
+	op       string
+	endpoint string
+}
+
+// Prometheus histogram
+type metricsHistogram struct {
+	counts []int64 // per bucket, the last one is +Inf
+	sum    float64
+	count  int64
+}
+
+func (h *metricsHistogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(metricsBuckets)+1)
+	}
+	i := sort.SearchFloat64s(metricsBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+type seriesMetrics struct {
+	requests  int64
+	bytes     int64
+	retries   int64
+	errors    map[string]int64 // by error class
+	durations metricsHistogram
+	ttfb      metricsHistogram
+}
+
+// Live metrics of the running tests served in Prometheus text format
+type metrics struct {
+	mtx      sync.Mutex
+	series   map[metricsSeries]*seriesMetrics
+	inFlight int64
+	server   *http.Server
+	listener net.Listener
+}
+
+func newMetrics() *metrics {
+	return &metrics{series: make(map[metricsSeries]*seriesMetrics)}
+}
+
+// Listen on addr and serve metrics in background
+func (m *metrics) serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.handle)
+	m.listener = ln
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(ln)
+	return nil
+}
+
+// Stop serving metrics
+func (m *metrics) close() error {
+	// the listener is not closed by the server before Serve starts
+	m.listener.Close()
+	return m.server.Close()
+}
+
+func (m *metrics) requestStarted() {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+func (m *metrics) requestDone() {
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
+// Count the completed request
+func (m *metrics) add(resp Resp) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key := metricsSeries{resp.top, resp.endpoint}
+	sm, ok := m.series[key]
+	if !ok {
+		sm = &seriesMetrics{errors: make(map[string]int64)}
+		m.series[key] = sm
+	}
+	sm.requests++
+	sm.retries += resp.retries
+	if resp.err != nil {
+		sm.errors[classifyError(resp.err)]++
+		return
+	}
+	for _, kerr := range resp.keyErrors {
+		sm.errors[classifyError(kerr)]++
+	}
+	sm.bytes += resp.numBytes
+	sm.durations.observe(resp.duration.Seconds())
+	sm.ttfb.observe(resp.ttfb.Seconds())
+}
+
+func (m *metrics) handle(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+
+	m.mtx.Lock()
+	keys := make([]metricsSeries, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].op != keys[j].op {
+			return keys[i].op < keys[j].op
+		}
+		return keys[i].endpoint < keys[j].endpoint
+	})
+
+	fmt.Fprintf(&b, "# HELP s3bench_requests_total Completed requests.\n# TYPE s3bench_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "s3bench_requests_total{%s} %d\n", k.labels(), m.series[k].requests)
+	}
+	fmt.Fprintf(&b, "# HELP s3bench_bytes_total Transferred bytes of successful requests.\n# TYPE s3bench_bytes_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "s3bench_bytes_total{%s} %d\n", k.labels(), m.series[k].bytes)
+	}
+	fmt.Fprintf(&b, "# HELP s3bench_retries_total Retries of completed requests.\n# TYPE s3bench_retries_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "s3bench_retries_total{%s} %d\n", k.labels(), m.series[k].retries)
+	}
+	fmt.Fprintf(&b, "# HELP s3bench_errors_total Errors by class.\n# TYPE s3bench_errors_total counter\n")
+	for _, k := range keys {
+		classes := make([]string, 0, len(m.series[k].errors))
+		for class := range m.series[k].errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(&b, "s3bench_errors_total{%s,code=\"%s\"} %d\n", k.labels(), escapeLabel(class), m.series[k].errors[class])
+		}
+	}
+	fmt.Fprintf(&b, "# HELP s3bench_request_duration_seconds Duration of successful requests.\n# TYPE s3bench_request_duration_seconds histogram\n")
+	for _, k := range keys {
+		m.series[k].durations.write(&b, "s3bench_request_duration_seconds", k.labels())
+	}
+	fmt.Fprintf(&b, "# HELP s3bench_request_ttfb_seconds Time to first byte of successful requests.\n# TYPE s3bench_request_ttfb_seconds histogram\n")
+	for _, k := range keys {
+		m.series[k].ttfb.write(&b, "s3bench_request_ttfb_seconds", k.labels())
+	}
+	m.mtx.Unlock()
+
+	fmt.Fprintf(&b, "# HELP s3bench_requests_in_flight Requests being sent by clients.\n# TYPE s3bench_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "s3bench_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+func (h *metricsHistogram) write(b *bytes.Buffer, name string, labels string) {
+	var cum int64
+	for i, le := range metricsBuckets {
+		if h.counts != nil {
+			cum += h.counts[i]
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, cum)
+	}
+	fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(b, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func (k metricsSeries) labels() string {
+	return fmt.Sprintf("op=\"%s\",endpoint=\"%s\"", escapeLabel(k.op), escapeLabel(k.endpoint))
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,65 @@
+package s3bench
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	if err := m.serve("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+
+	// the address is in use
+	if err := newMetrics().serve(m.listener.Addr().String()); err == nil {
+		t.Fatal("expected listen error")
+	}
+
+	m.requestStarted()
+	m.requestStarted()
+	m.requestDone()
+	m.add(Resp{top: opWrite, endpoint: "http://e1", numBytes: 100, duration: 3 * time.Millisecond, ttfb: time.Millisecond, retries: 2})
+	m.add(Resp{top: opWrite, endpoint: "http://e1", numBytes: 100, duration: 2 * time.Second})
+	m.add(Resp{top: opRead, endpoint: "http://e\"2", err: &net.OpError{Op: "dial", Err: errors.New("refused")}})
+
+	resp, err := http.Get("http://" + m.listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("content type %s", ct)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(b)
+	for _, line := range []string{
+		`# TYPE s3bench_requests_total counter`,
+		`s3bench_requests_total{op="Write",endpoint="http://e1"} 2`,
+		`s3bench_requests_total{op="Read",endpoint="http://e\"2"} 1`,
+		`s3bench_bytes_total{op="Write",endpoint="http://e1"} 200`,
+		`s3bench_retries_total{op="Write",endpoint="http://e1"} 2`,
+		`s3bench_errors_total{op="Read",endpoint="http://e\"2",code="Network"} 1`,
+		`# TYPE s3bench_request_duration_seconds histogram`,
+		`s3bench_request_duration_seconds_bucket{op="Write",endpoint="http://e1",le="0.001"} 0`,
+		`s3bench_request_duration_seconds_bucket{op="Write",endpoint="http://e1",le="0.005"} 1`,
+		`s3bench_request_duration_seconds_bucket{op="Write",endpoint="http://e1",le="2.5"} 2`,
+		`s3bench_request_duration_seconds_bucket{op="Write",endpoint="http://e1",le="+Inf"} 2`,
+		`s3bench_request_duration_seconds_count{op="Write",endpoint="http://e1"} 2`,
+		`s3bench_request_ttfb_seconds_count{op="Write",endpoint="http://e1"} 2`,
+		`s3bench_requests_in_flight 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("no line %s in\n%s", line, text)
+		}
+	}
+}
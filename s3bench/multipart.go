@@ -0,0 +1,177 @@
+package s3bench
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Send the request, returns the time to the response headers of the last
+// attempt, or to the failure if there is no response
+func sendTtfb(req *request.Request) (time.Duration, error) {
+	start := time.Now()
+	var ttfb time.Duration
+	req.Handlers.Send.PushBack(func(r *request.Request) {
+		if r.Error == nil {
+			// the body is not read yet
+			ttfb = time.Since(start)
+		}
+	})
+	err := req.Send()
+	if ttfb == 0 {
+		ttfb = time.Since(start)
+	}
+	return ttfb, err
+}
+
+// Upload data as a multipart object, parts are sent by up to
+// params.partConcurrency goroutines. Returns per-part durations and ttfb.
+func (params *Params) multipartUpload(svc *s3.S3, r *s3.CreateMultipartUploadInput, data []byte) ([]time.Duration, []time.Duration, error) {
+	numParts := (int64(len(data)) + params.partSize - 1) / params.partSize
+	return params.multipart(svc, r, numParts, func(uploadId *string, pn int64) (*string, time.Duration, error) {
+		off := pn * params.partSize
+		end := off + params.partSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		preq, presp := svc.UploadPartRequest(&s3.UploadPartInput{
+			Bucket:     r.Bucket,
+			Key:        r.Key,
+			UploadId:   uploadId,
+			PartNumber: aws.Int64(pn + 1),
+			Body:       bytes.NewReader(data[off:end]),
+		})
+		// Disable payload checksum calculation (very expensive)
+		preq.HTTPRequest.Header.Add("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		ttfb, err := sendTtfb(preq)
+		return presp.ETag, ttfb, err
+	})
+}
+
+// Copy object of given size by parts of params.copyPartSize using
+// UploadPartCopy. Returns per-part durations and ttfb.
+func (params *Params) multipartCopy(svc *s3.S3, r *s3.CopyObjectInput, size int64) ([]time.Duration, []time.Duration, error) {
+	numParts := (size + params.copyPartSize - 1) / params.copyPartSize
+	create := &s3.CreateMultipartUploadInput{Bucket: r.Bucket, Key: r.Key}
+	return params.multipart(svc, create, numParts, func(uploadId *string, pn int64) (*string, time.Duration, error) {
+		off := pn * params.copyPartSize
+		end := off + params.copyPartSize
+		if end > size {
+			end = size
+		}
+		preq, presp := svc.UploadPartCopyRequest(&s3.UploadPartCopyInput{
+			Bucket:          r.Bucket,
+			Key:             r.Key,
+			UploadId:        uploadId,
+			PartNumber:      aws.Int64(pn + 1),
+			CopySource:      r.CopySource,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+		})
+		ttfb, err := sendTtfb(preq)
+		if err != nil || presp.CopyPartResult == nil {
+			return nil, ttfb, err
+		}
+		return presp.CopyPartResult.ETag, ttfb, nil
+	})
+}
+
+// Run multipart upload of numParts parts, part pn (0-based) is sent by
+// sendPart which returns its ETag and ttfb. Parts are sent by up to
+// params.partConcurrency goroutines. Returns per-part durations and ttfb.
+func (params *Params) multipart(svc *s3.S3, r *s3.CreateMultipartUploadInput, numParts int64,
+	sendPart func(uploadId *string, pn int64) (*string, time.Duration, error)) ([]time.Duration, []time.Duration, error) {
+	req, resp := svc.CreateMultipartUploadRequest(r)
+	if err := req.Send(); err != nil {
+		return nil, nil, err
+	}
+
+	if numParts == 0 {
+		numParts = 1
+	}
+	completed := make([]*s3.CompletedPart, numParts)
+	partDurations := make([]time.Duration, numParts)
+	partTtfb := make([]time.Duration, numParts)
+
+	var wg sync.WaitGroup
+	var errMtx sync.Mutex
+	var firstErr error
+	parts := make(chan int64)
+	for w := uint(0); w < params.partConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pn := range parts {
+				partStartTime := time.Now()
+				etag, ttfb, err := sendPart(resp.UploadId, pn)
+				partTtfb[pn] = ttfb
+				partDurations[pn] = time.Since(partStartTime)
+				if err != nil {
+					errMtx.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("part %d: %w", pn+1, err)
+					}
+					errMtx.Unlock()
+					continue
+				}
+				completed[pn] = &s3.CompletedPart{ETag: etag, PartNumber: aws.Int64(pn + 1)}
+			}
+		}()
+	}
+	for pn := int64(0); pn < numParts; pn++ {
+		parts <- pn
+	}
+	close(parts)
+	wg.Wait()
+
+	if firstErr != nil {
+		// incomplete upload is aborted during cleanup
+		return partDurations, partTtfb, firstErr
+	}
+
+	creq, _ := svc.CompleteMultipartUploadRequest(&s3.CompleteMultipartUploadInput{
+		Bucket:          r.Bucket,
+		Key:             r.Key,
+		UploadId:        resp.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	return partDurations, partTtfb, creq.Send()
+}
+
+// Abort all incomplete multipart uploads under the object name prefix
+func (params *Params) abortMultipartUploads(svc *s3.S3, bucket string) {
+	numAborted := 0
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(params.objectNamePrefix),
+	}
+	for {
+		result, err := svc.ListMultipartUploads(input)
+		if err != nil {
+			params.printf("Cannot list multipart uploads (%v)\n", err)
+			break
+		}
+		for _, u := range result.Uploads {
+			_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   input.Bucket,
+				Key:      u.Key,
+				UploadId: u.UploadId,
+			})
+			if err == nil {
+				numAborted++
+			} else {
+				params.printf("Abort upload %s of %s failed (%v)\n", *u.UploadId, *u.Key, err)
+			}
+		}
+		if !aws.BoolValue(result.IsTruncated) {
+			break
+		}
+		input.KeyMarker = result.NextKeyMarker
+		input.UploadIdMarker = result.NextUploadIdMarker
+	}
+	params.printf("Aborted %d incomplete multipart uploads\n", numAborted)
+}
@@ -0,0 +1,533 @@
+package s3bench
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Operation of the tests. The loops submitting requests, the clients and
+// the report find operations by name in the registry, so a new workload
+// only needs to register its operation
+type operation interface {
+	// Name of the operation, used in the report and to select it
+	Name() string
+	// Number of requests of the test
+	Samples(params *Params) uint
+	// True if the requests create the objects: a test with -duration
+	// writes new objects, other tests access the written ones
+	WritesObjects() bool
+	// True if the objects are chosen by -keyDist
+	UsesKeyDist() bool
+	// True if the requests transfer object data, reported by dataReport
+	TransfersData() bool
+	// Request for i-th sample
+	Request(params *Params, i uint) Req
+	// Send the request with the client and fill the outcome in resp:
+	// err, ttfb, status, numBytes and operation specific stats
+	Execute(params *Params, svc *s3.S3, req Req, resp *Resp)
+	// Add operation specific stats of the result to the report
+	Report(r testResult, ret map[string]interface{})
+}
+
+var operations = map[string]operation{}
+
+// Register operation, it replaces operation of the same name
+func registerOperation(op operation) {
+	operations[op.Name()] = op
+}
+
+// Registered operation of the name
+func findOperation(name string) operation {
+	op, ok := operations[name]
+	if !ok {
+		panic("Developer error: unknown operation " + name)
+	}
+	return op
+}
+
+// Names of the registered operations in alphabetical order
+func OperationNames() []string {
+	names := make([]string, 0, len(operations))
+	for name := range operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	for _, op := range []operation{
+		writeOp{baseOp{opWrite}},
+		mpWriteOp{baseOp{opMpWrite}},
+		readOp{baseOp: baseOp{opRead}},
+		readOp{baseOp: baseOp{opRangedRead}, ranged: true},
+		readOp{baseOp: baseOp{opValidate}, validate: true},
+		headOp{baseOp{opHeadObj}},
+		putTagOp{baseOp{opPutObjTag}},
+		getTagOp{baseOp{opGetObjTag}},
+		listOp{baseOp{opList}},
+		copyOp{baseOp{opCopy}},
+		deleteOp{baseOp{opDelete}},
+		batchDeleteOp{baseOp{opBatchDelete}},
+	} {
+		registerOperation(op)
+	}
+}
+
+// Defaults of the operations: one request per sample read and no
+// operation specific stats
+type baseOp struct {
+	name string
+}
+
+func (o baseOp) Name() string {
+	return o.name
+}
+
+func (o baseOp) Samples(params *Params) uint {
+	return params.numSamples * params.sampleReads
+}
+
+func (o baseOp) WritesObjects() bool {
+	return false
+}
+
+func (o baseOp) UsesKeyDist() bool {
+	return false
+}
+
+func (o baseOp) TransfersData() bool {
+	return false
+}
+
+func (o baseOp) Report(r testResult, ret map[string]interface{}) {
+}
+
+// Object accessed by i-th request of operation op: its index, key and
+// size
+func (params *Params) objectFor(op operation, i uint) (uint, *string, int64) {
+	idx := params.keyStart + params.keyIndex(op, i)
+	sizes := params.sizes
+	if params.written != nil && !params.ownSizes {
+		// sizes of the phase which wrote the object
+		if ws := params.written.sizes(idx); ws != nil {
+			sizes = ws
+		}
+	}
+	return idx, genObjName(params.objectNamePrefix, data_hash_base32, idx), sizes.size(idx)
+}
+
+// True if the operations of the result transfer object data
+func (r testResult) transfersData() bool {
+	if op, ok := operations[r.operation]; ok {
+		return op.TransfersData()
+	}
+	return r.operation == opMix
+}
+
+// Transferred data and throughput of the result
+func dataReport(r testResult, ret map[string]interface{}) {
+	ret["Total Transferred (MB)"] = float64(r.bytesTransmitted) / (1024 * 1024)
+	ret["Total Throughput (MB/s)"] = (float64(r.bytesTransmitted) / (1024 * 1024)) / r.totalDuration.Seconds()
+}
+
+// Number and throughput of listed or deleted keys
+func keysReport(r testResult, ret map[string]interface{}, what string) {
+	ret["Total Keys "+what] = r.numKeys
+	ret["Total Keys Throughput (keys/s)"] = float64(r.numKeys) / r.totalDuration.Seconds()
+}
+
+type writeOp struct{ baseOp }
+
+func (o writeOp) Samples(params *Params) uint {
+	return params.numSamples
+}
+
+func (o writeOp) WritesObjects() bool {
+	return true
+}
+
+func (o writeOp) Request(params *Params, i uint) Req {
+	_, key, size := params.objectFor(o, i)
+	return Req{
+		top: o.name,
+		req: &s3.PutObjectInput{
+			Bucket: aws.String(params.bucketName),
+			Key:    key,
+			Body:   bytes.NewReader(bufferBytes[:size]),
+		},
+		size: size,
+	}
+}
+
+func (o writeOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	req, _ := svc.PutObjectRequest(r.req.(*s3.PutObjectInput))
+	// Disable payload checksum calculation (very expensive)
+	req.HTTPRequest.Header.Add("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	resp.err = req.Send()
+	resp.ttfb = time.Since(resp.start)
+	resp.status = httpStatus(req)
+	if resp.err == nil {
+		resp.numBytes = r.size
+	}
+}
+
+func (o writeOp) TransfersData() bool {
+	return true
+}
+
+func (o writeOp) Report(r testResult, ret map[string]interface{}) {
+	dataReport(r, ret)
+}
+
+type mpWriteOp struct{ baseOp }
+
+func (o mpWriteOp) Samples(params *Params) uint {
+	return params.numSamples
+}
+
+func (o mpWriteOp) WritesObjects() bool {
+	return true
+}
+
+func (o mpWriteOp) Request(params *Params, i uint) Req {
+	_, key, size := params.objectFor(o, i)
+	return Req{
+		top: o.name,
+		req: &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(params.bucketName),
+			Key:    key,
+		},
+		size: size,
+	}
+}
+
+func (o mpWriteOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	resp.partDurations, resp.partTtfb, resp.err = params.multipartUpload(svc, r.req.(*s3.CreateMultipartUploadInput), bufferBytes[:r.size])
+	resp.ttfb = time.Since(resp.start)
+	if resp.err == nil {
+		resp.numBytes = r.size
+	}
+}
+
+func (o mpWriteOp) TransfersData() bool {
+	return true
+}
+
+func (o mpWriteOp) Report(r testResult, ret map[string]interface{}) {
+	dataReport(r, ret)
+}
+
+// Read, RangedRead and Validate get the object data
+type readOp struct {
+	baseOp
+	ranged   bool // reads ranges of -rangeSize
+	validate bool // checks the data hash, every object is read once
+}
+
+func (o readOp) Samples(params *Params) uint {
+	if o.validate {
+		return params.numSamples
+	}
+	return params.numSamples * params.sampleReads
+}
+
+func (o readOp) UsesKeyDist() bool {
+	return !o.validate
+}
+
+func (o readOp) Request(params *Params, i uint) Req {
+	_, key, size := params.objectFor(o, i)
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(params.bucketName),
+		Key:    key,
+	}
+	if o.ranged {
+		var offset int64
+		offset, size = params.rangeFor(i, size)
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	}
+	return Req{
+		top:  o.name,
+		req:  input,
+		size: size,
+	}
+}
+
+func (o readOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	req, out := svc.GetObjectRequest(r.req.(*s3.GetObjectInput))
+	err := req.Send()
+	resp.ttfb = time.Since(resp.start)
+	resp.status = httpStatus(req)
+	var hasher hash.Hash
+	var numBytes int64
+	if err == nil {
+		if o.validate {
+			hasher = sha512.New()
+			numBytes, err = io.Copy(hasher, out.Body)
+		} else {
+			numBytes, err = io.Copy(ioutil.Discard, out.Body)
+		}
+	}
+	if err != nil {
+		numBytes = 0
+	} else if numBytes != r.size {
+		err = validationErrorf("expected object length %d, actual %d", r.size, numBytes)
+	}
+	if o.validate && err == nil {
+		err = validateHash(hasher.Sum(nil), r.size)
+	}
+	resp.numBytes, resp.err = numBytes, err
+}
+
+func (o readOp) TransfersData() bool {
+	return true
+}
+
+func (o readOp) Report(r testResult, ret map[string]interface{}) {
+	dataReport(r, ret)
+}
+
+type headOp struct{ baseOp }
+
+func (o headOp) UsesKeyDist() bool {
+	return true
+}
+
+func (o headOp) Request(params *Params, i uint) Req {
+	_, key, size := params.objectFor(o, i)
+	return Req{
+		top: o.name,
+		req: &s3.HeadObjectInput{
+			Bucket: aws.String(params.bucketName),
+			Key:    key,
+		},
+		size: size,
+	}
+}
+
+func (o headOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	req, out := svc.HeadObjectRequest(r.req.(*s3.HeadObjectInput))
+	resp.err = req.Send()
+	resp.ttfb = time.Since(resp.start)
+	resp.status = httpStatus(req)
+	if resp.err == nil {
+		// no data is transferred, numBytes is left 0
+		length := aws.Int64Value(out.ContentLength)
+		if length != r.size {
+			resp.err = validationErrorf("expected object length %d, actual %d, resp %v", r.size, length, out)
+		}
+	}
+}
+
+type putTagOp struct{ baseOp }
+
+func (o putTagOp) Samples(params *Params) uint {
+	return params.numSamples
+}
+
+func (o putTagOp) Request(params *Params, i uint) Req {
+	_, key, _ := params.objectFor(o, i)
+	tagSet := make([]*s3.Tag, 0, params.numTags)
+	for iTag := uint(0); iTag < params.numTags; iTag++ {
+		tagSet = append(tagSet, &s3.Tag{
+			Key:   aws.String(fmt.Sprintf("%s%d", params.tagNamePrefix, iTag)),
+			Value: aws.String(fmt.Sprintf("%s%d", params.tagValPrefix, iTag)),
+		})
+	}
+	return Req{
+		top: o.name,
+		req: &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(params.bucketName),
+			Key:     key,
+			Tagging: &s3.Tagging{TagSet: tagSet},
+		},
+	}
+}
+
+func (o putTagOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	req, _ := svc.PutObjectTaggingRequest(r.req.(*s3.PutObjectTaggingInput))
+	resp.err = req.Send()
+	resp.ttfb = time.Since(resp.start)
+	resp.status = httpStatus(req)
+}
+
+type getTagOp struct{ baseOp }
+
+func (o getTagOp) UsesKeyDist() bool {
+	return true
+}
+
+func (o getTagOp) Request(params *Params, i uint) Req {
+	_, key, _ := params.objectFor(o, i)
+	return Req{
+		top: o.name,
+		req: &s3.GetObjectTaggingInput{
+			Bucket: aws.String(params.bucketName),
+			Key:    key,
+		},
+	}
+}
+
+func (o getTagOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	req, _ := svc.GetObjectTaggingRequest(r.req.(*s3.GetObjectTaggingInput))
+	resp.err = req.Send()
+	resp.ttfb = time.Since(resp.start)
+	resp.status = httpStatus(req)
+}
+
+type listOp struct{ baseOp }
+
+func (o listOp) Samples(params *Params) uint {
+	return params.listSamples
+}
+
+func (o listOp) Request(params *Params, i uint) Req {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(params.bucketName),
+		Prefix:  aws.String(fmt.Sprintf("%s_%s_", params.objectNamePrefix, data_hash_base32)),
+		MaxKeys: aws.Int64(params.listMaxKeys),
+	}
+	if params.listDelimiter != "" {
+		input.Delimiter = aws.String(params.listDelimiter)
+	}
+	if params.listStartAfter != "" {
+		input.StartAfter = aws.String(params.listStartAfter)
+	}
+	return Req{
+		top: o.name,
+		req: input,
+	}
+}
+
+func (o listOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	resp.numKeys, resp.partDurations, resp.partTtfb, resp.err = params.listObjects(svc, r.req.(*s3.ListObjectsV2Input))
+	resp.ttfb = resp.partTtfb[0]
+}
+
+func (o listOp) Report(r testResult, ret map[string]interface{}) {
+	keysReport(r, ret, "Listed")
+}
+
+type copyOp struct{ baseOp }
+
+func (o copyOp) Samples(params *Params) uint {
+	return params.numSamples
+}
+
+func (o copyOp) Request(params *Params, i uint) Req {
+	idx, key, size := params.objectFor(o, i)
+	return Req{
+		top: o.name,
+		req: &s3.CopyObjectInput{
+			Bucket:     aws.String(params.copyDstBucket()),
+			Key:        genObjName(params.objectNamePrefix+copySuffix, data_hash_base32, idx),
+			CopySource: copySource(params.bucketName, *key),
+		},
+		size: size,
+	}
+}
+
+func (o copyOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	input := r.req.(*s3.CopyObjectInput)
+	if params.copyPartSize > 0 && r.size > params.copyPartSize {
+		resp.partDurations, resp.partTtfb, resp.err = params.multipartCopy(svc, input, r.size)
+	} else {
+		req, _ := svc.CopyObjectRequest(input)
+		resp.err = req.Send()
+		resp.status = httpStatus(req)
+	}
+	resp.ttfb = time.Since(resp.start)
+	if resp.err == nil {
+		resp.numBytes = r.size
+	}
+}
+
+func (o copyOp) TransfersData() bool {
+	return true
+}
+
+func (o copyOp) Report(r testResult, ret map[string]interface{}) {
+	dataReport(r, ret)
+}
+
+type deleteOp struct{ baseOp }
+
+func (o deleteOp) Samples(params *Params) uint {
+	return params.numSamples
+}
+
+func (o deleteOp) Request(params *Params, i uint) Req {
+	_, key, _ := params.objectFor(o, i)
+	return Req{
+		top: o.name,
+		req: &s3.DeleteObjectInput{
+			Bucket: aws.String(params.bucketName),
+			Key:    key,
+		},
+	}
+}
+
+func (o deleteOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	req, _ := svc.DeleteObjectRequest(r.req.(*s3.DeleteObjectInput))
+	resp.err = req.Send()
+	resp.ttfb = time.Since(resp.start)
+	resp.status = httpStatus(req)
+	if resp.err == nil {
+		resp.numKeys = 1
+	}
+}
+
+func (o deleteOp) Report(r testResult, ret map[string]interface{}) {
+	keysReport(r, ret, "Deleted")
+}
+
+// Delete of the objects by batches of params.deleteAtOnce
+type batchDeleteOp struct{ baseOp }
+
+func (o batchDeleteOp) Samples(params *Params) uint {
+	return (params.numSamples + uint(params.deleteAtOnce) - 1) / uint(params.deleteAtOnce)
+}
+
+func (o batchDeleteOp) Request(params *Params, i uint) Req {
+	keyList := make([]*s3.ObjectIdentifier, 0, params.deleteAtOnce)
+	for k := i * uint(params.deleteAtOnce); k < (i+1)*uint(params.deleteAtOnce) && k < params.numSamples; k++ {
+		keyList = append(keyList, &s3.ObjectIdentifier{
+			Key: genObjName(params.objectNamePrefix, data_hash_base32, params.keyStart+k),
+		})
+	}
+	return Req{
+		top: o.name,
+		req: &s3.DeleteObjectsInput{
+			Bucket: aws.String(params.bucketName),
+			Delete: &s3.Delete{Objects: keyList},
+		},
+	}
+}
+
+func (o batchDeleteOp) Execute(params *Params, svc *s3.S3, r Req, resp *Resp) {
+	req, out := svc.DeleteObjectsRequest(r.req.(*s3.DeleteObjectsInput))
+	resp.err = req.Send()
+	resp.ttfb = time.Since(resp.start)
+	resp.status = httpStatus(req)
+	if resp.err == nil {
+		resp.numKeys = int64(len(out.Deleted))
+		for _, e := range out.Errors {
+			resp.keyErrors = append(resp.keyErrors, keyError{
+				aws.StringValue(e.Key), aws.StringValue(e.Code), aws.StringValue(e.Message)})
+		}
+	}
+}
+
+func (o batchDeleteOp) Report(r testResult, ret map[string]interface{}) {
+	keysReport(r, ret, "Deleted")
+}
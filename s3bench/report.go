@@ -0,0 +1,163 @@
+package s3bench
+
+import (
+	"fmt"
+)
+
+func (params Params) reportPrepare(tests []testResult) map[string]interface{} {
+	report := make(map[string]interface{})
+	report["Parameters"] = params.report()
+	testreps := make([]map[string]interface{}, 0, len(tests))
+	for _, r := range tests {
+		testreps = append(testreps, r.report())
+	}
+	report["Tests"] = testreps
+	return report
+}
+
+func (r testResult) report() map[string]interface{} {
+	ret := make(map[string]interface{})
+	ret["Operation"] = r.operation
+	if r.name != "" {
+		ret["Name"] = r.name
+	}
+	ret["Total Requests Count"] = r.opDurations.count()
+	if r.totalDuration == 0 {
+		// merged histograms have no totals
+		statsReport(ret, "Duration", r.opDurations)
+		statsReport(ret, "Ttfb", r.opTtfb)
+		statsReport(ret, "Part Duration", r.partDurations)
+		statsReport(ret, "Part Ttfb", r.partTtfb)
+		statsReport(ret, "Retried Duration", r.retriedDurations)
+		return ret
+	}
+	if op, ok := operations[r.operation]; ok {
+		op.Report(r, ret)
+	} else if r.operation == opMix {
+		dataReport(r, ret)
+	}
+	ret["Total Duration (s)"] = r.totalDuration.Seconds()
+	if r.partial {
+		ret["Partial"] = true
+	}
+	if r.keyDist != "" {
+		ret["Key Distribution"] = r.keyDist
+	}
+	if r.targetRate > 0 {
+		ret["Target Rate (ops/s)"] = r.targetRate
+		ret["Achieved Rate (ops/s)"] = float64(r.numRequests())/r.totalDuration.Seconds()
+	}
+
+	statsReport(ret, "Duration", r.opDurations)
+	statsReport(ret, "Ttfb", r.opTtfb)
+
+	ret["Retried Requests Count"] = r.numRetried
+	ret["Retries Count"] = r.numRetries
+	statsReport(ret, "Retried Duration", r.retriedDurations)
+
+	if r.partDurations.count() > 0 {
+		partName := "Part"
+		if r.operation == opList {
+			partName = "Page"
+		}
+		ret["Total " + partName + "s Count"] = r.partDurations.count()
+		statsReport(ret, partName + " Duration", r.partDurations)
+		statsReport(ret, partName + " Ttfb", r.partTtfb)
+	}
+
+	if len(r.endpoints) > 1 {
+		ret["Endpoints"] = r.endpointsReport()
+	}
+
+	if len(r.timeline) > 0 {
+		timeline := make([]map[string]interface{}, 0, len(r.timeline))
+		for _, w := range r.timeline {
+			timeline = append(timeline, w.report())
+		}
+		ret["Timeline"] = timeline
+	}
+
+	ret["Errors Count"] = r.numErrors
+	ret["Error Classes"] = r.errorsReport()
+	if r.traceDropped > 0 {
+		ret["Trace Dropped Records Count"] = r.traceDropped
+	}
+	if r.keepErrors {
+		ret["Errors"] = r.opErrors
+	}
+	return ret
+}
+
+// Add max/avg/min and percentiles of the histogram to the report
+func statsReport(ret map[string]interface{}, name string, h *Histogram) {
+	if h.count() == 0 {
+		return
+	}
+	ret[name + " Max"] = h.percentile(100)
+	ret[name + " Avg"] = h.avg()
+	ret[name + " Min"] = h.percentile(0)
+	ret[name + " 99.99th-ile"] = h.percentile(99.99)
+	ret[name + " 99.9th-ile"] = h.percentile(99.9)
+	ret[name + " 99th-ile"] = h.percentile(99)
+	ret[name + " 90th-ile"] = h.percentile(90)
+	ret[name + " 75th-ile"] = h.percentile(75)
+	ret[name + " 50th-ile"] = h.percentile(50)
+	ret[name + " 25th-ile"] = h.percentile(25)
+}
+
+func (params Params) report() map[string]interface{} {
+	ret := make(map[string]interface{})
+	ret["endpoints"] =  params.endpoints
+	ret["bucket"] = params.bucketName
+	ret["objectNamePrefix"] = params.objectNamePrefix
+	ret["objectSize (MB)"] = float64(params.objectSize)/(1024*1024)
+	ret["objectSizeDist"] = params.sizes.dist.spec
+	ret["sizeSeed"] = params.sizes.seed
+	ret["numClients"] = params.numClients
+	ret["numSamples"] = params.numSamples
+	ret["sampleReads"] = params.sampleReads
+	ret["verbose"] = params.verbose
+	ret["headObj"] = params.headObj
+	ret["clientDelay"] = params.clientDelay
+	ret["deleteAtOnce"] = params.deleteAtOnce
+	ret["deleteMode"] = params.deleteMode
+	ret["numTags"] = params.numTags
+	ret["putObjTag"] = params.putObjTag
+	ret["getObjTag"] = params.getObjTag
+	ret["readObj"] = params.readObj
+	ret["tagNamePrefix"] = params.tagNamePrefix
+	ret["tagValPrefix"] = params.tagValPrefix
+	ret["validate"] = params.validate
+	ret["skipWrite"] = params.skipWrite
+	ret["skipRead"] = params.skipRead
+	ret["partSize (MB)"] = float64(params.partSize)/(1024*1024)
+	ret["partConcurrency"] = params.partConcurrency
+	ret["rangeSize (MB)"] = float64(params.rangeSize)/(1024*1024)
+	ret["rangeOffset"] = params.rangeOffset
+	ret["rangePattern"] = params.rangePattern
+	mix := []string{}
+	for _, m := range params.mix {
+		mix = append(mix, fmt.Sprintf("%s=%d", m.op, m.weight))
+	}
+	ret["mix"] = mix
+	ret["duration (s)"] = params.duration.Seconds()
+	ret["interval (s)"] = params.interval.Seconds()
+	ret["rate (ops/s)"] = params.rate
+	ret["rateDist"] = params.rateDist
+	ret["keyDist"] = params.keys.spec
+	ret["copyObj"] = params.copyObj
+	ret["copyBucket"] = params.copyDstBucket()
+	ret["copyPartSize (MB)"] = float64(params.copyPartSize)/(1024*1024)
+	ret["listObj"] = params.listObj
+	ret["listSamples"] = params.listSamples
+	ret["listMaxKeys"] = params.listMaxKeys
+	ret["listDelimiter"] = params.listDelimiter
+	ret["listStartAfter"] = params.listStartAfter
+	ret["scenario"] = params.scenario
+	ret["controller"] = params.agents
+	ret["requestTimeout (s)"] = params.requestTimeout.Seconds()
+	ret["maxRetries"] = params.maxRetries
+	ret["retryMinDelay (s)"] = params.retryMinDelay.Seconds()
+	ret["retryMaxDelay (s)"] = params.retryMaxDelay.Seconds()
+	return ret
+}
@@ -0,0 +1,250 @@
+package s3bench
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// sample data and its hash are global, so only one test runs at a time
+var runMtx sync.Mutex
+
+// Runner runs the tests of the config, see Run
+type Runner struct {
+	config  Config
+	params  *Params // validated config, used for the report
+	metrics *metrics
+}
+
+// Validate the config and start the metrics server if configured, the
+// server runs until Close
+func NewRunner(c Config) (*Runner, error) {
+	if c.ScenarioFile != "" && len(c.Scenario) == 0 {
+		// agents do not have the file
+		b, err := ioutil.ReadFile(c.ScenarioFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scenario: %v", err)
+		}
+		c.Scenario = b
+	}
+	params, _, err := c.params()
+	if err != nil {
+		return nil, err
+	}
+	r := &Runner{config: c, params: params}
+	if c.MetricsAddr != "" {
+		r.metrics = newMetrics()
+		if err := r.metrics.serve(c.MetricsAddr); err != nil {
+			return nil, fmt.Errorf("cannot serve metrics: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// Run the tests and clean up. Cancel of ctx stops sending requests, the
+// results of completed requests are returned with ctx.Err() then
+func (r *Runner) Run(ctx context.Context) ([]Result, error) {
+	runMtx.Lock()
+	defer runMtx.Unlock()
+
+	params, phases, err := r.config.params()
+	if err != nil {
+		return nil, err
+	}
+	params.metrics = r.metrics
+	if r.config.TraceFile != "" {
+		params.trace, err = newTraceWriter(r.config.TraceFile, r.config.TraceFormat)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create trace file: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(params.interrupted)
+		case <-done:
+		}
+	}()
+
+	var testResults []testResult
+	if len(params.agents) > 0 {
+		testResults, err = params.runController(r.config)
+		if err != nil {
+			err = fmt.Errorf("controller failed: %v", err)
+		}
+	} else {
+		testResults, err = params.runSafe(r.config.awsConfig(), phases, r.config.SkipCleanup)
+	}
+
+	if params.trace != nil {
+		if terr := params.trace.close(); terr != nil && err == nil {
+			err = fmt.Errorf("cannot write trace file: %v", terr)
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	results := make([]Result, 0, len(testResults))
+	for _, tr := range testResults {
+		results = append(results, newPublicResult(tr))
+	}
+	return results, err
+}
+
+// Stop the metrics server
+func (r *Runner) Close() error {
+	if r.metrics == nil {
+		return nil
+	}
+	return r.metrics.close()
+}
+
+// Report of the results in the format of the command line tool
+func (r *Runner) Report(results []Result) map[string]interface{} {
+	tests := make([]testResult, 0, len(results))
+	for _, res := range results {
+		tests = append(tests, res.result)
+	}
+	return r.params.reportPrepare(tests)
+}
+
+// Run the tests locally, failures of the setup are returned as error
+func (params *Params) runSafe(cfg *aws.Config, phases []scenarioPhase, skipCleanup bool) (ret []testResult, err error) {
+	// sample data of the previous run
+	bufferBytes = nil
+	data_hash_base32 = ""
+	dataHashes = sync.Map{}
+
+	defer func() {
+		p := recover()
+		// clients of failed setup would be left in the caller's process
+		params.stopClients()
+		if p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+	return params.runLocal(cfg, phases, skipCleanup), nil
+}
+
+func (params *Params) isInterrupted() bool {
+	select {
+	case <-params.interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Result of a test
+type Result struct {
+	Operation    string
+	Name         string // scenario phase
+	Requests     int64  // completed requests, both successful and failed
+	Failed       int64
+	Errors       int64
+	Bytes        int64
+	Keys         int64 // listed or deleted keys
+	Duration     time.Duration
+	Partial      bool // stopped before all requests were sent
+	ErrorClasses map[string]int64
+	Retried      int64 // requests with retries, both successful and failed
+	Retries      int64
+	TraceDropped int64 // requests not written to the trace file, it is written too slowly
+	result       testResult
+}
+
+func newPublicResult(r testResult) Result {
+	ret := Result{
+		Operation:    r.operation,
+		Name:         r.name,
+		Requests:     r.numRequests(),
+		Failed:       r.numFailed,
+		Errors:       r.numErrors,
+		Bytes:        r.bytesTransmitted,
+		Keys:         r.numKeys,
+		Duration:     r.totalDuration,
+		Partial:      r.partial,
+		ErrorClasses: make(map[string]int64),
+		Retried:      r.numRetried,
+		Retries:      r.numRetries,
+		TraceDropped: r.traceDropped,
+		result:       r,
+	}
+	for class, ec := range r.errorClasses {
+		ret.ErrorClasses[class] = ec.count
+	}
+	return ret
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// p-th percentile of durations of successful requests, p in range [0..100]
+func (r Result) Latency(p float64) time.Duration {
+	return seconds(r.result.opDurations.percentile(p))
+}
+
+// p-th percentile of durations of successful requests with retries
+func (r Result) RetriedLatency(p float64) time.Duration {
+	return seconds(r.result.retriedDurations.percentile(p))
+}
+
+// p-th percentile of time to first byte of successful requests
+func (r Result) Ttfb(p float64) time.Duration {
+	return seconds(r.result.opTtfb.percentile(p))
+}
+
+// MB/s
+func (r Result) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Duration.Seconds() / (1024 * 1024)
+}
+
+// Report of the result, the same as a test in the report of the command
+// line tool
+func (r Result) Report() map[string]interface{} {
+	return r.result.report()
+}
+
+// Completed request passed to Config.OnRequest
+type Request struct {
+	Operation string
+	Key       string
+	Endpoint  string
+	Client    uint
+	Start     time.Time
+	Duration  time.Duration
+	Ttfb      time.Duration
+	Bytes     int64
+	Keys      int64
+	Status    int   // HTTP status
+	Retries   int64 // retries of all the HTTP requests of the operation
+	Err       error
+}
+
+func (resp Resp) request() Request {
+	return Request{
+		Operation: resp.top,
+		Key:       resp.key,
+		Endpoint:  resp.endpoint,
+		Client:    resp.clientId,
+		Start:     resp.start,
+		Duration:  resp.duration,
+		Ttfb:      resp.ttfb,
+		Bytes:     resp.numBytes,
+		Keys:      resp.numKeys,
+		Status:    resp.status,
+		Retries:   resp.retries,
+		Err:       resp.err,
+	}
+}
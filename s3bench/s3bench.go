@@ -0,0 +1,532 @@
+package s3bench
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	mathrand "math/rand"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var bufferBytes []byte
+var data_hash_base32 string
+var data_hash [sha512.Size]byte
+
+// true if created
+// false if existed
+func (params *Params) prepareBucket(cfg *aws.Config, bucketName string) bool {
+	cfg.Endpoint = aws.String(params.endpoints[0])
+	svc := s3.New(session.New(), cfg)
+	req, _ := svc.CreateBucketRequest(
+		&s3.CreateBucketInput{Bucket: aws.String(bucketName)})
+
+	err := req.Send()
+
+	if err == nil {
+		return true
+	} else if !strings.Contains(err.Error(), "BucketAlreadyOwnedByYou:") &&
+		!strings.Contains(err.Error(), "BucketAlreadyExists:") {
+		panic("Failed to create bucket: " + err.Error())
+	}
+
+	return false
+}
+
+// Prepare the data, buckets and clients, run the tests and clean up
+func (params *Params) runLocal(cfg *aws.Config, phases []scenarioPhase, skipCleanup bool) []testResult {
+	params.prepareData(cfg)
+
+	bucketCreated := params.prepareBucket(cfg, params.bucketName)
+	copyBucketCreated := false
+	if params.copyObj && params.copyDstBucket() != params.bucketName {
+		copyBucketCreated = params.prepareBucket(cfg, params.copyDstBucket())
+	}
+
+	params.StartClients(cfg)
+
+	var testResults []testResult
+	if len(phases) > 0 {
+		testResults = params.runScenario(cfg, phases)
+	} else {
+		testResults = params.runTests()
+	}
+
+	if !skipCleanup {
+		testResults = append(testResults, params.cleanup(cfg, bucketCreated, copyBucketCreated))
+	}
+	return testResults
+}
+
+// Generate the sample data to write or, without Write test, read its
+// hash from the stored objects
+func (params *Params) prepareData(cfg *aws.Config) {
+	if !params.skipWrite {
+		// Generate the data from which we will do the writting
+		params.printf("Generating in-memory sample data...\n")
+		timeGenData := time.Now()
+		bufferBytes = make([]byte, params.objectSize, params.objectSize)
+		_, err := rand.Read(bufferBytes)
+		if err != nil {
+			panic("Could not allocate a buffer")
+		}
+		data_hash = sha512.Sum512(bufferBytes)
+		data_hash_base32 = to_b32(data_hash[:])
+		params.printf("Done (%s)\n", time.Since(timeGenData))
+	}
+
+	if data_hash_base32 == "" {
+		var err error
+		data_hash_base32, err = params.getObjectHash(cfg)
+		if err != nil {
+			panic(fmt.Sprintf("Cannot read object hash:> %v", err))
+		}
+		var hash_from_b32 []byte
+		hash_from_b32, err = from_b32(data_hash_base32)
+		if err != nil {
+			panic(fmt.Sprintf("Cannot convert object hash:> %v", err))
+		}
+		copy(data_hash[:], hash_from_b32)
+	}
+}
+
+// Delete the objects and the buckets created by the tests, returns result
+// of the delete test
+func (params *Params) cleanup(cfg *aws.Config, bucketCreated bool, copyBucketCreated bool) testResult {
+	params.printf("Cleaning up %d objects...\n", params.numSamples)
+	svc := s3.New(session.New(), cfg)
+
+	if params.putObjTag {
+		for i := uint(0); i < params.numSamples; i++ {
+			key := genObjName(params.objectNamePrefix, data_hash_base32, i)
+			deleteObjectTaggingInput := &s3.DeleteObjectTaggingInput{
+					Bucket: aws.String(params.bucketName),
+					Key:    key,
+			}
+			_, err := svc.DeleteObjectTagging(deleteObjectTaggingInput)
+			params.printf("Delete tags %s |err %v\n", *key, err)
+		}
+	}
+
+	deleteOp := opBatchDelete
+	if params.deleteMode == deleteSingle {
+		deleteOp = opDelete
+	}
+	params.printf("Running %s test...\n", deleteOp)
+	result := params.Run(deleteOp)
+	params.printf("Successfully deleted %d/%d objects in %s\n", result.numKeys, params.numSamples, result.totalDuration)
+
+	if params.partSize > 0 {
+		params.abortMultipartUploads(svc, params.bucketName)
+	}
+
+	if params.copyObj {
+		params.deleteCopies(svc)
+		if params.copyPartSize > 0 {
+			params.abortMultipartUploads(svc, params.copyDstBucket())
+		}
+	}
+
+	// buckets are deleted when all agents deleted their objects
+	params.stepStart(stepDeleteBuckets)
+	defer params.stepDone(nil)
+
+	if bucketCreated {
+		params.printf("Deleting bucket...\n")
+		dltpar := &s3.DeleteBucketInput{
+			Bucket: aws.String(params.bucketName)}
+		_, err := svc.DeleteBucket(dltpar)
+		if err == nil {
+			params.printf("Succeeded\n")
+		} else {
+			params.printf("Failed (%v)\n", err)
+		}
+	}
+
+	if copyBucketCreated {
+		params.printf("Deleting copy bucket...\n")
+		_, err := svc.DeleteBucket(&s3.DeleteBucketInput{
+			Bucket: aws.String(params.copyDstBucket())})
+		if err == nil {
+			params.printf("Succeeded\n")
+		} else {
+			params.printf("Failed (%v)\n", err)
+		}
+	}
+	return result
+}
+
+// Run the tests selected by the flags in the fixed order
+func (params *Params) runTests() []testResult {
+	testResults := []testResult{}
+
+	if !params.skipWrite && !params.isInterrupted() {
+		writeOp := opWrite
+		if params.partSize > 0 {
+			writeOp = opMpWrite
+		}
+		params.printf("Running %s test...\n", writeOp)
+		result := params.Run(writeOp)
+		testResults = append(testResults, result)
+		if (params.duration > 0 || result.partial) && result.numRequests() > 0 {
+			// the following tests and cleanup cycle over the written
+			// objects
+			params.numSamples = uint(result.numRequests())
+		}
+	}
+	if len(params.mix) > 0 && !params.isInterrupted() {
+		params.printf("Running %s test...\n", opMix)
+		testResults = append(testResults, params.RunMix()...)
+	}
+	if params.putObjTag && len(params.mix) == 0 && !params.isInterrupted() {
+		params.printf("Running %s test...\n", opPutObjTag)
+		testResults = append(testResults, params.Run(opPutObjTag))
+	}
+	if params.getObjTag && len(params.mix) == 0 && !params.isInterrupted() {
+		params.printf("Running %s test...\n", opGetObjTag)
+		testResults = append(testResults, params.Run(opGetObjTag))
+	}
+	if params.headObj && len(params.mix) == 0 && !params.isInterrupted() {
+		params.printf("Running %s test...\n", opHeadObj)
+		testResults = append(testResults, params.Run(opHeadObj))
+	}
+	if params.readObj && len(params.mix) == 0 && !params.isInterrupted() {
+		readOp := opRead
+		if params.rangeSize > 0 {
+			readOp = opRangedRead
+		}
+		params.printf("Running %s test...\n", readOp)
+		testResults = append(testResults, params.Run(readOp))
+	}
+	if params.listObj && !params.isInterrupted() {
+		params.printf("Running %s test...\n", opList)
+		testResults = append(testResults, params.Run(opList))
+	}
+	if params.validate && !params.isInterrupted() {
+		params.printf("Running %s test...\n", opValidate)
+		testResults = append(testResults, params.Run(opValidate))
+	}
+	if params.copyObj && !params.isInterrupted() {
+		params.printf("Running %s test...\n", opCopy)
+		testResults = append(testResults, params.Run(opCopy))
+	}
+	return testResults
+}
+
+func (params *Params) Run(op string) testResult {
+	params.stepStart(op)
+	params.test = op
+	results := params.run(findOperation(op).Samples(params), []string{op}, func(uint) string { return op })
+	params.stepDone([]testResult{*results[op]})
+	return *results[op]
+}
+
+// Run operations of the mix concurrently, every request gets operation
+// chosen randomly according to the mix weights.
+// Returns result per operation and combined result of all operations
+func (params *Params) RunMix() []testResult {
+	var totalWeight uint
+	ops := make([]string, 0, len(params.mix))
+	for _, m := range params.mix {
+		totalWeight += m.weight
+		ops = append(ops, m.op)
+	}
+	opFor := func(uint) string {
+		w := uint(mathrand.Intn(int(totalWeight)))
+		for _, m := range params.mix {
+			if w < m.weight {
+				return m.op
+			}
+			w -= m.weight
+		}
+		panic("Developer error")
+	}
+
+	params.stepStart(opMix)
+	params.test = opMix
+	results := params.run(params.numSamples * params.sampleReads, ops, opFor)
+
+	ret := make([]testResult, 0, len(params.mix) + 1)
+	for _, m := range params.mix {
+		results[m.op].targetRate = params.rate * float64(m.weight) / float64(totalWeight)
+		ret = append(ret, *results[m.op])
+	}
+	ret = append(ret, *results[opMix])
+	params.stepDone(ret)
+	return ret
+}
+
+// Submit numReqs requests with operations (one of ops) chosen by opFor,
+// collect and aggregate stats per operation.
+// Combined stats are stored under opMix key
+func (params *Params) run(numReqs uint, ops []string, opFor func(uint) string) map[string]*testResult {
+	startTime := time.Now()
+
+	// Start submitting load requests
+	duration := params.duration
+	rate := params.rate
+	stop := params.interrupted
+	for _, op := range ops {
+		if op == opDelete || op == opBatchDelete {
+			// all the objects are deleted as fast as possible
+			// regardless of -duration, -rate and interrupt
+			duration = 0
+			rate = 0
+			stop = nil
+		}
+	}
+	submitted := make(chan uint, 1)
+	go params.submitLoad(numReqs, duration, rate, opFor, stop, submitted)
+
+	results := make(map[string]*testResult)
+	for _, op := range append([]string{opMix}, ops...) {
+		result := newResult(op)
+		result.keepErrors = params.verbose
+		results[op] = &result
+	}
+	// results shown in the timeline
+	timelineOps := ops
+	if len(ops) > 1 {
+		timelineOps = append(append([]string{}, ops...), opMix)
+	}
+	var ticks <-chan time.Time
+	if params.interval > 0 {
+		ticker := time.NewTicker(params.interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+		for _, op := range timelineOps {
+			results[op].startWindow(0)
+		}
+	}
+	// Collect and aggregate stats for completed requests until all
+	// submitted requests are drained
+	allSubmitted := false
+	partial := false
+	for i := uint(0); !allSubmitted || i < numReqs; {
+		select {
+		case numReqs = <-submitted:
+			allSubmitted = true
+			partial = stop != nil && params.isInterrupted()
+		case <-ticks:
+			now := time.Since(startTime)
+			for _, op := range timelineOps {
+				results[op].closeWindow(now)
+				results[op].startWindow(now)
+			}
+		case resp := <-params.responses:
+			results[resp.top].add(resp, i)
+			if len(ops) > 1 {
+				results[opMix].add(resp, i)
+			}
+			if params.trace != nil && !params.trace.add(resp) {
+				results[resp.top].traceDropped++
+				if len(ops) > 1 {
+					results[opMix].traceDropped++
+				}
+			}
+			if params.metrics != nil {
+				params.metrics.add(resp)
+			}
+			if params.onRequest != nil {
+				params.onRequest(resp.request())
+			}
+			i++
+			params.printf("operation %s(%d) completed in %.2fs|%s\n", resp.top, i, resp.duration.Seconds(), resp.err)
+		}
+	}
+
+	if params.interval > 0 {
+		now := time.Since(startTime)
+		for _, op := range timelineOps {
+			results[op].closeWindow(now)
+		}
+	}
+
+	for _, result := range results {
+		result.totalDuration = time.Since(startTime)
+		result.targetRate = rate
+		result.partial = partial
+		result.name = params.phase
+		if op, ok := operations[result.operation]; ok && op.UsesKeyDist() {
+			result.keyDist = params.keys.spec
+		}
+	}
+	return results
+}
+
+func newResult(op string) testResult {
+	return testResult{
+		operation:        op,
+		opDurations:      newHistogram(),
+		opTtfb:           newHistogram(),
+		partDurations:    newHistogram(),
+		partTtfb:         newHistogram(),
+		retriedDurations: newHistogram(),
+	}
+}
+
+// number of completed requests, both successful and failed
+func (result *testResult) numRequests() int64 {
+	return result.opDurations.count() + result.numFailed
+}
+
+// Add stats of i-th completed request to the result
+func (result *testResult) add(resp Resp, i uint) {
+	if resp.err != nil {
+		errStr := fmt.Sprintf("%v(%d) completed in %0.2fs with error %s",
+			resp.top, i+1, resp.duration.Seconds(), resp.err)
+		result.addError(resp.err, errStr)
+		result.numFailed++
+	} else {
+		result.bytesTransmitted = result.bytesTransmitted + resp.numBytes
+		result.opDurations.record(resp.duration)
+		result.opTtfb.record(resp.ttfb)
+		result.numKeys += resp.numKeys
+	}
+	if resp.retries > 0 {
+		result.numRetried++
+		result.numRetries += resp.retries
+		if resp.err == nil {
+			result.retriedDurations.record(resp.duration)
+		}
+	}
+	for _, kerr := range resp.keyErrors {
+		result.addError(kerr, fmt.Sprintf("%v(%d) %s", resp.top, i+1, kerr))
+	}
+	if result.window != nil {
+		result.window.add(resp)
+	}
+	result.addEndpoint(resp)
+	for pi := range resp.partDurations {
+		result.partDurations.record(resp.partDurations[pi])
+		result.partTtfb.record(resp.partTtfb[pi])
+	}
+}
+
+// Create individual load requests and submit them to the client queue.
+// numReqs requests are submitted or, if duration is set, requests are
+// submitted until it expires. Submitting stops early when stop is closed.
+// Number of submitted requests is sent to the submitted channel at the end
+func (params *Params) submitLoad(numReqs uint, duration time.Duration, rate float64, opFor func(uint) string,
+	stop <-chan struct{}, submitted chan<- uint) {
+	startTime := time.Now()
+	deadline := startTime.Add(duration)
+	sched := startTime
+	i := uint(0)
+submit:
+	for ; duration > 0 || i < numReqs; i++ {
+		if duration > 0 && !time.Now().Before(deadline) {
+			break
+		}
+		req := findOperation(opFor(i)).Request(params, i)
+		if rate > 0 {
+			// open-loop load: request is sent at its scheduled time
+			// or immediately if the schedule is behind
+			if params.rateDist == ratePoisson {
+				sched = sched.Add(time.Duration(mathrand.ExpFloat64() / rate * float64(time.Second)))
+			} else {
+				sched = startTime.Add(time.Duration(float64(i) / rate * float64(time.Second)))
+			}
+			select {
+			case <-time.After(time.Until(sched)):
+			case <-stop:
+				break submit
+			}
+			req.sched = sched
+		}
+		select {
+		case params.requests <- req:
+		case <-stop:
+			break submit
+		}
+	}
+	submitted <- i
+}
+
+func (params *Params) StartClients(cfg *aws.Config) {
+	if params.clients == nil {
+		params.clients = &sync.WaitGroup{}
+	}
+	for i := 0; i < int(params.numClients); i++ {
+		endpoint := params.endpoints[i%len(params.endpoints)]
+		clientCfg := cfg.Copy()
+		clientCfg.Endpoint = aws.String(endpoint)
+		params.clients.Add(1)
+		go params.startClient(clientCfg, endpoint, uint(i))
+		if params.clientDelay > 0 {
+			time.Sleep(time.Duration(params.clientDelay) *
+				time.Millisecond)
+		} else if params.clientDelay < 0 {
+			time.Sleep(time.Duration(mathrand.Intn(-params.clientDelay)) *
+				time.Millisecond)
+		}
+	}
+}
+
+// Close the request queue and wait for the clients to exit, responses of
+// the requests being sent are dropped
+func (params *Params) stopClients() {
+	close(params.requests)
+	if params.clients == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		params.clients.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-params.responses:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Run an individual load request
+func (params *Params) startClient(cfg *aws.Config, endpoint string, clientId uint) {
+	defer params.clients.Done()
+	svc := s3.New(session.New(), cfg)
+	// retries of the requests sent by the running operation, parts of
+	// multipart upload are sent concurrently
+	var retries int64
+	svc.Handlers.Complete.PushBack(func(r *request.Request) {
+		atomic.AddInt64(&retries, int64(r.RetryCount))
+	})
+	for request := range params.requests {
+		if params.metrics != nil {
+			params.metrics.requestStarted()
+		}
+		startTime := time.Now()
+		if !request.sched.IsZero() {
+			// count queueing delay of rate limited requests in
+			startTime = request.sched
+		}
+		resp := Resp{
+			top:      request.top,
+			key:      requestKey(request.req),
+			endpoint: endpoint,
+			clientId: clientId,
+			start:    startTime,
+		}
+		atomic.StoreInt64(&retries, 0)
+		findOperation(request.top).Execute(params, svc, request, &resp)
+		resp.retries = atomic.LoadInt64(&retries)
+		if resp.status == 0 {
+			resp.status = errStatus(resp.err)
+		}
+		if params.metrics != nil {
+			params.metrics.requestDone()
+		}
+		resp.duration = time.Since(startTime)
+		params.responses <- resp
+	}
+}
@@ -0,0 +1,594 @@
+package s3bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// Start in-memory S3 and return parameters of a small run against it
+func testParams(t *testing.T, s *s3Stub) (*Params, *aws.Config) {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	// sample data of the previous test
+	bufferBytes = nil
+	data_hash_base32 = ""
+	dataHashes = sync.Map{}
+
+	sizes, err := parseSizeDist("64Kb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parseKeyDist(keySequential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := &Params{
+		requests:         make(chan Req),
+		responses:        make(chan Resp),
+		interrupted:      make(chan struct{}),
+		numSamples:       20,
+		numClients:       4,
+		objectSize:       sizes.max,
+		sizes:            newKeySizes(sizes, 1),
+		objectNamePrefix: "test",
+		bucketName:       "bucket",
+		endpoints:        []string{srv.URL},
+		sampleReads:      2,
+		deleteAtOnce:     6,
+		deleteMode:       deleteBatch,
+		numTags:          3,
+		tagNamePrefix:    "tag_name_",
+		tagValPrefix:     "tag_val_",
+		partConcurrency:  2,
+		rangePattern:     rangeRandom,
+		rateDist:         rateConstant,
+		keys:             keys,
+		listSamples:      2,
+		listMaxKeys:      7,
+	}
+	cfg := &aws.Config{
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}
+	return params, cfg
+}
+
+// Check the operations of the results and that they have no errors
+func checkResults(t *testing.T, results []testResult, ops ...string) {
+	t.Helper()
+	if len(results) != len(ops) {
+		t.Fatalf("expected %d results, got %d", len(ops), len(results))
+	}
+	for i, r := range results {
+		if r.operation != ops[i] {
+			t.Errorf("result %d: expected %s, got %s", i, ops[i], r.operation)
+		}
+		if r.numErrors > 0 {
+			t.Errorf("%s: %d errors %v", r.operation, r.numErrors, r.errorsReport())
+		}
+	}
+}
+
+// Check that the created buckets are deleted with all objects and uploads
+func checkCleanedUp(t *testing.T, s *s3Stub) {
+	t.Helper()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for name, objects := range s.buckets {
+		t.Errorf("bucket %s with %d objects is left", name, len(objects))
+	}
+	if len(s.uploads) > 0 {
+		t.Errorf("%d uploads are left", len(s.uploads))
+	}
+}
+
+func TestRunOperations(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	params, cfg := testParams(t, s)
+	params.putObjTag = true
+	params.getObjTag = true
+	params.headObj = true
+	params.readObj = true
+	params.listObj = true
+	params.validate = true
+	params.copyObj = true
+	params.copyBucket = "copies"
+
+	results := params.runLocal(cfg, nil, false)
+	checkResults(t, results, opWrite, opPutObjTag, opGetObjTag, opHeadObj, opRead, opList, opValidate, opCopy, opBatchDelete)
+	numKeys := map[string]int64{
+		opList:        int64(params.numSamples * params.listSamples),
+		opBatchDelete: int64(params.numSamples),
+	}
+	for _, r := range results {
+		if n := findOperation(r.operation).Samples(params); r.numRequests() != int64(n) {
+			t.Errorf("%s: expected %d requests, got %d", r.operation, n, r.numRequests())
+		}
+		if r.numKeys != numKeys[r.operation] {
+			t.Errorf("%s: expected %d keys, got %d", r.operation, numKeys[r.operation], r.numKeys)
+		}
+	}
+	if n := results[4].bytesTransmitted; n != int64(params.numSamples*params.sampleReads)*params.objectSize {
+		t.Errorf("%s: read %d bytes", opRead, n)
+	}
+	if n := results[3].bytesTransmitted; n != 0 {
+		t.Errorf("%s: transferred %d bytes", opHeadObj, n)
+	}
+	checkCleanedUp(t, s)
+}
+
+func TestRunMultipart(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	params, cfg := testParams(t, s)
+	params.partSize = 20 << 10
+	params.rangeSize = 4 << 10
+	params.readObj = true
+	params.validate = true
+	params.copyObj = true
+	params.copyPartSize = 16 << 10
+	params.deleteMode = deleteSingle
+
+	results := params.runLocal(cfg, nil, false)
+	checkResults(t, results, opMpWrite, opRangedRead, opValidate, opCopy, opDelete)
+	if n := results[0].partDurations.count(); n != int64(params.numSamples)*4 {
+		t.Errorf("%s: %d parts", opMpWrite, n)
+	}
+	// ttfb of the part is measured to its response headers
+	if pt, pd := results[0].partTtfb, results[0].partDurations; pt.count() != pd.count() || pt.Sum > pd.Sum {
+		t.Errorf("%s: part ttfb %+v, part durations %+v", opMpWrite, pt, pd)
+	}
+	checkCleanedUp(t, s)
+}
+
+func TestRunSkipWrite(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	params, cfg := testParams(t, s)
+	checkResults(t, params.runLocal(cfg, nil, true), opWrite)
+
+	// objects of the previous run are read back by their name
+	read, _ := testParams(t, s)
+	read.endpoints = params.endpoints
+	read.skipWrite = true
+	read.readObj = true
+	read.validate = true
+	checkResults(t, read.runLocal(cfg, nil, false), opRead, opValidate, opBatchDelete)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if n := len(s.buckets[params.bucketName]); n > 0 {
+		// the bucket created by the first run is kept
+		t.Errorf("%d objects are left", n)
+	}
+}
+
+func TestRunMix(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	params, cfg := testParams(t, s)
+	var err error
+	params.mix, err = params.parseMix("read=2,head=1,gettag=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, params.runLocal(cfg, nil, false), opWrite, opRead, opHeadObj, opGetObjTag, opMix, opBatchDelete)
+	checkCleanedUp(t, s)
+}
+
+func TestRunDurationMix(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	params, cfg := testParams(t, s)
+	params.duration = 500 * time.Millisecond
+	params.rate = 200
+	var err error
+	params.mix, err = params.parseMix("read=7,write=2,head=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := params.runLocal(cfg, nil, false)
+	// the mix reads the objects written by the Write test
+	checkResults(t, results, opWrite, opRead, opWrite, opHeadObj, opMix, opBatchDelete)
+	if n := results[0].numRequests(); n <= 20 {
+		t.Errorf("Write test wrote %d objects, expected new objects until the duration expires", n)
+	}
+	if r := results[len(results)-1]; r.targetRate != 0 {
+		t.Errorf("%s: cleanup is paced at %g ops/s", r.operation, r.targetRate)
+	}
+	checkCleanedUp(t, s)
+}
+
+func TestRunInjectedErrors(t *testing.T) {
+	params, cfg := testParams(t, newS3Stub(0, 0, 0))
+	params.prepareData(cfg)
+	params.prepareBucket(cfg, params.bucketName)
+
+	// clients send requests to the failing server
+	failing := httptest.NewServer(newS3Stub(0, 0, 1))
+	defer failing.Close()
+	params.endpoints = []string{failing.URL}
+	params.StartClients(cfg)
+	result := params.Run(opWrite)
+	params.stopClients()
+
+	if result.numFailed != int64(params.numSamples) {
+		t.Fatalf("%d of %d requests failed", result.numFailed, params.numSamples)
+	}
+	if ec := result.errorClasses["S3 SlowDown (HTTP 503)"]; ec == nil || ec.count != int64(params.numSamples) {
+		t.Fatalf("error classes %v", result.errorsReport())
+	}
+}
+
+func TestRunner(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c := DefaultConfig()
+	c.AccessKey = "key"
+	c.AccessSecret = "secret"
+	c.Endpoints = []string{srv.URL}
+	c.ObjectSize = "16Kb"
+	c.NumClients = 2
+	c.NumSamples = 10
+	c.ClientDelay = 0
+	c.Ops = "write,head,list"
+	var mtx sync.Mutex
+	requests := map[string]int{}
+	c.OnRequest = func(r Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		requests[r.Operation]++
+		if r.Err != nil {
+			t.Errorf("%s %s: %v", r.Operation, r.Key, r.Err)
+		}
+	}
+	runner, err := NewRunner(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the runner can be run again
+	for i := 0; i < 2; i++ {
+		results, err := runner.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := []string{opWrite, opHeadObj, opList, opBatchDelete}
+		if len(results) != len(ops) {
+			t.Fatalf("expected %d results, got %d", len(ops), len(results))
+		}
+		for j, r := range results {
+			if r.Operation != ops[j] || r.Failed > 0 {
+				t.Errorf("result %d: %s with %d failed requests", j, r.Operation, r.Failed)
+			}
+		}
+		if results[0].Requests != 10 || results[0].Bytes != 10*16<<10 {
+			t.Errorf("%s: %d requests, %d bytes", opWrite, results[0].Requests, results[0].Bytes)
+		}
+		if results[2].Keys != 10 {
+			t.Errorf("%s: %d keys", opList, results[2].Keys)
+		}
+		if tests := runner.Report(results)["Tests"].([]map[string]interface{}); len(tests) != len(ops) {
+			t.Errorf("report of %d tests", len(tests))
+		}
+		checkCleanedUp(t, s)
+	}
+	if requests[opWrite] != 20 || requests[opHeadObj] != 20 {
+		t.Errorf("requests passed to OnRequest %v", requests)
+	}
+}
+
+func TestRunnerCancel(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c := DefaultConfig()
+	c.AccessKey = "key"
+	c.AccessSecret = "secret"
+	c.Endpoints = []string{srv.URL}
+	c.ObjectSize = "4Kb"
+	c.NumClients = 2
+	c.NumSamples = 10
+	c.ClientDelay = 0
+	c.Duration = time.Hour
+	runner, err := NewRunner(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	results, err := runner.Run(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if len(results) == 0 || !results[0].Partial {
+		t.Fatalf("expected partial %s result, got %v", opWrite, results)
+	}
+	checkCleanedUp(t, s)
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for name, update := range map[string]func(c *Config){
+		"no endpoints": func(c *Config) { c.Endpoints = nil },
+		"numClients":   func(c *Config) { c.NumClients = c.NumSamples + 1 },
+		"deleteMode":   func(c *Config) { c.DeleteMode = "all" },
+		"objectSize":   func(c *Config) { c.ObjectSize = "big" },
+		"partSize":     func(c *Config) { c.PartSize = "5Xb" },
+		"rangeOffset":  func(c *Config) { c.RangeSize = "1Kb"; c.RangeOffset = "1Gb" },
+		"mix and ops":  func(c *Config) { c.Mix = "read=1"; c.Ops = "write" },
+		"agents trace": func(c *Config) { c.Agents = []string{"host:7000"}; c.TraceFile = "trace.csv" },
+		"unknown op":   func(c *Config) { c.Ops = "write,rename" },
+		"no scenario":  func(c *Config) { c.ScenarioFile = "/nonexistent.json" },
+	} {
+		c := DefaultConfig()
+		c.Endpoints = []string{"http://localhost:9000"}
+		update(&c)
+		if _, err := NewRunner(c); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSizeDistLognormal(t *testing.T) {
+	// the tail sizes the data buffer
+	for spec, max := range map[string]int64{
+		"lognormal:1Mb,256Kb": 1<<20 + 3*256<<10,
+		"lognormal:1Gb,1Gb":   4 << 30,
+	} {
+		d, err := parseSizeDist(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.max != max {
+			t.Errorf("%s: max size %d, expected %d", spec, d.max, max)
+		}
+		rnd := mathrand.New(mathrand.NewSource(1))
+		for i := 0; i < 1000; i++ {
+			if sz := d.next(rnd); sz < 1 || sz > max {
+				t.Fatalf("%s: size %d", spec, sz)
+			}
+		}
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	s := newS3Stub(0, 0, 0.3)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c := DefaultConfig()
+	c.AccessKey = "key"
+	c.AccessSecret = "secret"
+	c.Endpoints = []string{srv.URL}
+	c.ObjectSize = "4Kb"
+	c.NumClients = 4
+	c.NumSamples = 40
+	c.ClientDelay = 0
+	c.MaxRetries = 20
+	c.RetryMinDelay = time.Millisecond
+	c.RetryMaxDelay = 2 * time.Millisecond
+	var retries int64
+	c.OnRequest = func(r Request) {
+		atomic.AddInt64(&retries, r.Retries)
+	}
+	runner, err := NewRunner(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := runner.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, r := range results {
+		if r.Failed > 0 {
+			t.Errorf("%s: %d failed requests %v", r.Operation, r.Failed, r.ErrorClasses)
+		}
+		if r.Retries < r.Retried {
+			t.Errorf("%s: %d retries of %d retried requests", r.Operation, r.Retries, r.Retried)
+		}
+		total += r.Retries
+	}
+	if results[0].Retried == 0 || results[0].RetriedLatency(100) == 0 {
+		t.Errorf("%s: no retried requests", results[0].Operation)
+	}
+	if total != retries {
+		t.Errorf("%d retries in results, %d passed to OnRequest", total, retries)
+	}
+	rep := results[0].Report()
+	if rep["Retried Requests Count"] != results[0].Retried || rep["Retried Duration Max"] == nil {
+		t.Errorf("no retries in report %v", rep)
+	}
+	checkCleanedUp(t, s)
+}
+
+func TestRunRequestTimeout(t *testing.T) {
+	params, cfg := testParams(t, newS3Stub(0, 0, 0))
+	params.prepareData(cfg)
+	params.prepareBucket(cfg, params.bucketName)
+
+	// clients send requests to the hung server
+	hung := httptest.NewServer(newS3Stub(time.Second, 0, 0))
+	defer hung.Close()
+	params.endpoints = []string{hung.URL}
+	c := Config{
+		AccessKey:      "key",
+		AccessSecret:   "secret",
+		Region:         "us-east-1",
+		RequestTimeout: 50 * time.Millisecond,
+		MaxRetries:     1,
+		RetryMinDelay:  time.Millisecond,
+		RetryMaxDelay:  time.Millisecond,
+	}
+	params.StartClients(c.awsConfig())
+	result := params.Run(opWrite)
+	params.stopClients()
+
+	if result.numFailed != int64(params.numSamples) {
+		t.Fatalf("%d of %d requests failed", result.numFailed, params.numSamples)
+	}
+	if ec := result.errorClasses["Network timeout"]; ec == nil || ec.count != int64(params.numSamples) {
+		t.Fatalf("error classes %v", result.errorsReport())
+	}
+	if result.numRetried != int64(params.numSamples) || result.numRetries != int64(params.numSamples) {
+		t.Fatalf("%d retried requests, %d retries", result.numRetried, result.numRetries)
+	}
+	if max := result.totalDuration; max > time.Second {
+		t.Fatalf("requests are not timed out in %s", max)
+	}
+}
+
+func TestStopClients(t *testing.T) {
+	params, cfg := testParams(t, newS3Stub(0, 0, 0))
+	params.StartClients(cfg)
+	// the response is not collected like after a failure of the test
+	params.requests <- headOp{baseOp{opHeadObj}}.Request(params, 0)
+	stopped := make(chan struct{})
+	go func() {
+		params.stopClients()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("clients are not stopped")
+	}
+}
+
+func TestRunnerClose(t *testing.T) {
+	c := DefaultConfig()
+	c.Endpoints = []string{"http://localhost:9000"}
+	c.MetricsAddr = "127.0.0.1:0"
+	runner, err := NewRunner(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MetricsAddr = runner.metrics.listener.Addr().String()
+	if _, err := NewRunner(c); err == nil {
+		t.Fatal("expected error of the address in use")
+	}
+	if err := runner.Close(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewRunner(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+}
+
+func TestEndpointsReport(t *testing.T) {
+	for op, data := range map[string]bool{opRead: true, opHeadObj: false, opMix: true, opList: false} {
+		r := newResult(op)
+		for i, ep := range []string{"http://a", "http://b"} {
+			r.add(Resp{top: op, endpoint: ep, duration: time.Millisecond, numBytes: 100}, uint(i))
+		}
+		r.totalDuration = time.Second
+		for _, rep := range r.endpointsReport() {
+			_, transferred := rep["Total Transferred (MB)"]
+			_, throughput := rep["Total Throughput (MB/s)"]
+			if transferred != data || throughput != data {
+				t.Errorf("%s: endpoint report %v", op, rep)
+			}
+		}
+	}
+}
+
+func TestMergeHistogramFiles(t *testing.T) {
+	tests := []struct{ name, op string }{
+		{"fill", opWrite}, {"small", opWrite}, {"", opWrite}, {"", opRead}, {"", opWrite}, {"", opBatchDelete},
+	}
+	dir := t.TempDir()
+	files := []string{}
+	for f := 0; f < 2; f++ {
+		results := []Result{}
+		for i, tt := range tests {
+			r := newResult(tt.op)
+			r.name = tt.name
+			// i+1 requests of i-th test
+			for j := 0; j <= i; j++ {
+				r.add(Resp{top: tt.op, duration: time.Duration(i+1) * time.Millisecond}, uint(j))
+			}
+			results = append(results, newPublicResult(r))
+		}
+		fn := filepath.Join(dir, fmt.Sprintf("run%d.json", f))
+		if err := SaveHistograms(fn, results); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, fn)
+	}
+
+	merged, err := MergeHistogramFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != len(tests) {
+		t.Fatalf("expected %d results, got %d", len(tests), len(merged))
+	}
+	for i, r := range merged {
+		if r.Name != tests[i].name || r.Operation != tests[i].op || r.Requests != int64(2*(i+1)) ||
+			r.Latency(100) != time.Duration(i+1)*time.Millisecond {
+			t.Errorf("result %d: %s %s with %d requests and max %v", i, r.Name, r.Operation, r.Requests, r.Latency(100))
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	for err, class := range map[error]string{
+		reset:               "Network",
+		io.ErrUnexpectedEOF: "Network",
+		fmt.Errorf("read body: %w", io.ErrUnexpectedEOF):                         "Network",
+		awserr.New("SerializationError", "failed", reset):                        "Network",
+		awserr.New("RequestError", "send request failed", errors.New("refused")): "Network",
+		&net.DNSError{IsTimeout: true}:                                           "Network timeout",
+		validationErrorf("bad length"):                                           "Validation",
+		errors.New("other"):                                                      "Other",
+	} {
+		if c := classifyError(err); c != class {
+			t.Errorf("%v: class %s, expected %s", err, c, class)
+		}
+	}
+}
+
+func TestRunScenarioSizes(t *testing.T) {
+	s := newS3Stub(0, 0, 0)
+	params, cfg := testParams(t, s)
+	// phases without objectSize access the objects with the sizes of the
+	// phase which wrote them, not the 64Kb of the command line
+	phases, err := params.parseScenario([]byte(`{"phases": [
+		{"name": "fill", "operation": "write", "objectSize": "4Kb", "numSamples": 10},
+		{"name": "large", "operation": "write", "objectSize": "uniform:1Kb-16Kb", "numSamples": 10, "keyStart": 10},
+		{"name": "read", "operation": "read", "numSamples": 20},
+		{"name": "head", "operation": "head", "numSamples": 20},
+		{"name": "validate", "operation": "validate", "numSamples": 20}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := params.runLocal(cfg, phases, false)
+	checkResults(t, results, opWrite, opWrite, opRead, opHeadObj, opValidate, opBatchDelete)
+	if n := results[0].bytesTransmitted; n != 10*4096 {
+		t.Errorf("fill: wrote %d bytes", n)
+	}
+	if n := results[2].bytesTransmitted; n != int64(params.sampleReads)*(results[0].bytesTransmitted+results[1].bytesTransmitted) {
+		t.Errorf("read: read %d bytes", n)
+	}
+	checkCleanedUp(t, s)
+}
@@ -0,0 +1,313 @@
+package s3bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Scenario file, omitted phase fields are taken from the command line
+type scenarioFile struct {
+	Phases []struct {
+		Name        string   `json:"name"`
+		Operation   string   `json:"operation"`
+		Mix         string   `json:"mix"`
+		NumClients  *uint    `json:"numClients"`
+		ObjectSize  string   `json:"objectSize"`
+		NumSamples  *uint    `json:"numSamples"`
+		SampleReads *uint    `json:"sampleReads"`
+		Duration    string   `json:"duration"`
+		Rate        *float64 `json:"rate"`
+		KeyStart    uint     `json:"keyStart"`
+		KeyDist     string   `json:"keyDist"`
+	} `json:"phases"`
+}
+
+// Phase of the scenario run as a separate test
+type scenarioPhase struct {
+	name        string
+	op          string
+	mix         []mixOp
+	numClients  uint
+	objectSize  int64
+	sizes       *keySizes
+	numSamples  uint
+	sampleReads uint
+	duration    time.Duration
+	rate        float64
+	keyStart    uint
+	keys        *keyDist
+	ownSizes    bool // objectSize is set, otherwise sizes of the written objects are used
+}
+
+// True if the phase writes objects
+func (ph scenarioPhase) writesObjects() bool {
+	if len(ph.mix) == 0 {
+		return findOperation(ph.op).WritesObjects()
+	}
+	for _, m := range ph.mix {
+		if findOperation(m.op).WritesObjects() {
+			return true
+		}
+	}
+	return false
+}
+
+// Key ranges written by the phases with their sizes
+type writtenKeys struct {
+	ranges []writtenRange
+}
+
+type writtenRange struct {
+	start uint
+	end   uint
+	sizes *keySizes
+}
+
+func (wk *writtenKeys) add(start, end uint, sizes *keySizes) {
+	wk.ranges = append(wk.ranges, writtenRange{start, end, sizes})
+}
+
+// Sizes of the last phase which wrote the object, nil if not written
+func (wk *writtenKeys) sizes(idx uint) *keySizes {
+	for i := len(wk.ranges) - 1; i >= 0; i-- {
+		if r := wk.ranges[i]; idx >= r.start && idx < r.end {
+			return r.sizes
+		}
+	}
+	return nil
+}
+
+// Load phases of the scenario file
+func (params *Params) loadScenario(fileName string) ([]scenarioPhase, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	phases, err := params.parseScenario(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return phases, nil
+}
+
+// Parse phases of the scenario
+func (params *Params) parseScenario(b []byte) ([]scenarioPhase, error) {
+	sf := scenarioFile{}
+	err := json.Unmarshal(b, &sf)
+	if err != nil {
+		return nil, err
+	}
+	if len(sf.Phases) == 0 {
+		return nil, fmt.Errorf("no phases")
+	}
+
+	names := params.opNames()
+
+	ret := make([]scenarioPhase, 0, len(sf.Phases))
+	for i, sp := range sf.Phases {
+		ph := scenarioPhase{
+			name:        sp.Name,
+			numClients:  params.numClients,
+			objectSize:  params.objectSize,
+			sizes:       params.sizes,
+			numSamples:  params.numSamples,
+			sampleReads: params.sampleReads,
+			duration:    params.duration,
+			rate:        params.rate,
+			keyStart:    sp.KeyStart,
+			keys:        params.keys,
+		}
+		if ph.name == "" {
+			ph.name = fmt.Sprintf("phase %d", i+1)
+		}
+		phaseErr := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s: %s", ph.name, fmt.Sprintf(format, args...))
+		}
+
+		if (sp.Operation == "") == (sp.Mix == "") {
+			return nil, phaseErr("either operation or mix should be set")
+		}
+		ops := []string{}
+		if sp.Mix != "" {
+			if ph.mix, err = params.parseMix(sp.Mix); err != nil {
+				return nil, phaseErr("invalid mix: %v", err)
+			}
+			for _, m := range ph.mix {
+				ops = append(ops, m.op)
+			}
+		} else {
+			op, ok := names[strings.ToLower(sp.Operation)]
+			if !ok {
+				return nil, phaseErr("unknown operation %q", sp.Operation)
+			}
+			ph.op = op
+			ops = append(ops, op)
+		}
+		for _, op := range ops {
+			if err := params.checkOp(op); err != nil {
+				return nil, phaseErr("%v", err)
+			}
+		}
+
+		if sp.NumClients != nil {
+			ph.numClients = *sp.NumClients
+		}
+		if sp.NumSamples != nil {
+			ph.numSamples = *sp.NumSamples
+		}
+		if sp.SampleReads != nil {
+			ph.sampleReads = *sp.SampleReads
+		}
+		if ph.numClients < 1 || ph.numSamples < 1 || ph.sampleReads < 1 {
+			return nil, phaseErr("numClients, numSamples and sampleReads cannot be less than 1")
+		}
+		if sp.ObjectSize != "" {
+			dist, err := parseSizeDist(sp.ObjectSize)
+			if err != nil {
+				return nil, phaseErr("invalid objectSize: %v", err)
+			}
+			ph.objectSize = dist.max
+			ph.sizes = newKeySizes(dist, params.sizes.seed)
+			ph.ownSizes = true
+		}
+		if sp.Duration != "" {
+			if ph.duration, err = time.ParseDuration(sp.Duration); err != nil {
+				return nil, phaseErr("invalid duration: %v", err)
+			}
+		}
+		if sp.Rate != nil {
+			ph.rate = *sp.Rate
+			if ph.rate < 0 {
+				return nil, phaseErr("rate cannot be negative")
+			}
+		}
+		if sp.KeyDist != "" {
+			if ph.keys, err = parseKeyDist(sp.KeyDist); err != nil {
+				return nil, phaseErr("invalid keyDist: %v", err)
+			}
+		}
+		if ph.objectSize > params.objectSize {
+			// sample data should fit the largest object
+			params.objectSize = ph.objectSize
+		}
+		ret = append(ret, ph)
+	}
+	return ret, nil
+}
+
+// Check that the operation can run with the parameters and prepare them
+// for the operation
+func (params *Params) checkOp(op string) error {
+	switch {
+	case op == opMpWrite && params.partSize == 0:
+		return fmt.Errorf("%s requires -partSize", op)
+	case op == opRangedRead && params.rangeSize == 0:
+		return fmt.Errorf("%s requires -rangeSize", op)
+	case findOperation(op).WritesObjects() && params.skipWrite:
+		return fmt.Errorf("%s cannot run with -skipWrite", op)
+	case op == opCopy:
+		// copy bucket is prepared and copies are cleaned up
+		params.copyObj = true
+	case op == opPutObjTag:
+		// tags are cleaned up
+		params.putObjTag = true
+	}
+	return nil
+}
+
+// Phases running comma separated operations one by one with the
+// parameters of the command line
+func (params *Params) parseOps(spec string) ([]scenarioPhase, error) {
+	names := params.opNames()
+	ret := []scenarioPhase{}
+	for _, name := range strings.Split(spec, ",") {
+		op, ok := names[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q", name)
+		}
+		if err := params.checkOp(op); err != nil {
+			return nil, err
+		}
+		ret = append(ret, scenarioPhase{
+			op:          op,
+			numClients:  params.numClients,
+			objectSize:  params.objectSize,
+			sizes:       params.sizes,
+			numSamples:  params.numSamples,
+			sampleReads: params.sampleReads,
+			duration:    params.duration,
+			rate:        params.rate,
+			keys:        params.keys,
+		})
+	}
+	return ret, nil
+}
+
+// Run the phases one by one, each phase has its own clients. Objects of
+// all the phases are cleaned up at the end
+func (params *Params) runScenario(cfg *aws.Config, phases []scenarioPhase) []testResult {
+	testResults := []testResult{}
+	numKeys := uint(0)
+	written := &writtenKeys{}
+	for _, ph := range phases {
+		if params.isInterrupted() {
+			break
+		}
+		p := *params
+		p.requests = make(chan Req)
+		p.responses = make(chan Resp)
+		p.clients = nil
+		p.numClients = ph.numClients
+		p.objectSize = ph.objectSize
+		p.sizes = ph.sizes
+		p.numSamples = ph.numSamples
+		p.sampleReads = ph.sampleReads
+		p.duration = ph.duration
+		p.rate = ph.rate
+		p.keyStart = ph.keyStart
+		p.keys = ph.keys
+		p.mix = ph.mix
+		p.phase = ph.name
+		p.ownSizes = ph.ownSizes
+		p.written = written
+		p.StartClients(cfg)
+
+		if ph.name != "" {
+			params.printf("Running %s...\n", ph.name)
+		} else {
+			params.printf("Running %s test...\n", ph.op)
+		}
+		var results []testResult
+		if len(ph.mix) > 0 {
+			results = p.RunMix()
+		} else {
+			results = []testResult{p.Run(ph.op)}
+		}
+		p.stopClients()
+
+		end := ph.keyStart + ph.numSamples
+		for i := range results {
+			op := results[i].operation
+			if len(ph.mix) == 0 && ph.duration > 0 && findOperation(op).WritesObjects() {
+				// new objects are written until the duration expires
+				end = ph.keyStart + uint(results[i].numRequests())
+			}
+		}
+		if end > numKeys {
+			numKeys = end
+		}
+		if ph.ownSizes && ph.writesObjects() {
+			// phases without objectSize access the objects with
+			// these sizes
+			written.add(ph.keyStart, end, ph.sizes)
+		}
+		testResults = append(testResults, results...)
+	}
+	params.numSamples = numKeys
+	return testResults
+}
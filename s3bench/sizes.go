@@ -0,0 +1,209 @@
+package s3bench
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	sizeFixed     = "fixed"
+	sizeUniform   = "uniform"
+	sizeLognormal = "lognormal"
+	sizeBuckets   = "buckets"
+
+	// lognormal sizes are at most that many times the mean
+	lognormalMaxMult = 4
+)
+
+// Distribution of object sizes
+type sizeDist struct {
+	kind    string
+	spec    string
+	min     int64 // uniform range, fixed size and lognormal cap
+	max     int64
+	mu      float64
+	sigma   float64
+	sizes   []int64 // weighted buckets
+	weights []uint
+	total   uint
+}
+
+// Sizes chosen for objects, the same seed gives the same sizes
+type keySizes struct {
+	mtx   sync.Mutex
+	dist  *sizeDist
+	seed  int64
+	rnd   *mathrand.Rand
+	sizes []int64
+}
+
+// parse object size specification, one of:
+//
+//	80Mb
+//	uniform:4Kb-16Mb
+//	lognormal:1Mb,256Kb
+//	4Kb:60,1Mb:30,64Mb:10
+func parseSizeDist(spec string) (*sizeDist, error) {
+	d := &sizeDist{spec: spec}
+	var err error
+	switch {
+	case strings.HasPrefix(spec, sizeUniform+":"):
+		d.kind = sizeUniform
+		rng := strings.Split(spec[len(sizeUniform+":"):], "-")
+		if len(rng) != 2 {
+			return nil, fmt.Errorf("expected %s:min-max", sizeUniform)
+		}
+		if d.min, err = parseSizeErr(rng[0]); err != nil {
+			return nil, err
+		}
+		if d.max, err = parseSizeErr(rng[1]); err != nil {
+			return nil, err
+		}
+		if d.min < 1 || d.min > d.max {
+			return nil, fmt.Errorf("invalid range %s", spec)
+		}
+	case strings.HasPrefix(spec, sizeLognormal+":"):
+		d.kind = sizeLognormal
+		ms := strings.Split(spec[len(sizeLognormal+":"):], ",")
+		if len(ms) != 2 {
+			return nil, fmt.Errorf("expected %s:mean,stddev", sizeLognormal)
+		}
+		mean, err := parseSizeErr(ms[0])
+		if err != nil {
+			return nil, err
+		}
+		stddev, err := parseSizeErr(ms[1])
+		if err != nil {
+			return nil, err
+		}
+		if mean < 1 {
+			return nil, fmt.Errorf("mean cannot be less than 1b")
+		}
+		// parameters of the underlying normal distribution
+		d.sigma = math.Sqrt(math.Log(1 + float64(stddev*stddev)/float64(mean*mean)))
+		d.mu = math.Log(float64(mean)) - d.sigma*d.sigma/2
+		// the tail is cut to keep the data buffer reasonable, the buffer
+		// is sized to the largest object
+		d.min = 1
+		d.max = mean + 3*stddev
+		if d.max > lognormalMaxMult*mean {
+			d.max = lognormalMaxMult * mean
+		}
+	case strings.Contains(spec, ":"):
+		d.kind = sizeBuckets
+		for _, b := range strings.Split(spec, ",") {
+			sw := strings.Split(strings.TrimSpace(b), ":")
+			if len(sw) != 2 {
+				return nil, fmt.Errorf("expected size:weight, got %q", b)
+			}
+			sz, err := parseSizeErr(sw[0])
+			if err != nil {
+				return nil, err
+			}
+			w, err := strconv.ParseUint(sw[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight of %s: %v", sw[0], err)
+			}
+			d.sizes = append(d.sizes, sz)
+			d.weights = append(d.weights, uint(w))
+			d.total += uint(w)
+			if sz > d.max {
+				d.max = sz
+			}
+			if d.min == 0 || sz < d.min {
+				d.min = sz
+			}
+		}
+		if d.total == 0 {
+			return nil, fmt.Errorf("total weight cannot be 0")
+		}
+	default:
+		d.kind = sizeFixed
+		if d.max, err = parseSizeErr(spec); err != nil {
+			return nil, err
+		}
+		d.min = d.max
+	}
+	return d, nil
+}
+
+// parse_size which returns error instead of panic
+func parseSizeErr(sz string) (ret int64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid size %q", sz)
+		}
+	}()
+	return parse_size(strings.TrimSpace(sz)), nil
+}
+
+func (d *sizeDist) next(rnd *mathrand.Rand) int64 {
+	switch d.kind {
+	case sizeUniform:
+		return d.min + rnd.Int63n(d.max-d.min+1)
+	case sizeLognormal:
+		sz := int64(math.Exp(d.mu + d.sigma*rnd.NormFloat64()))
+		if sz < d.min {
+			sz = d.min
+		} else if sz > d.max {
+			sz = d.max
+		}
+		return sz
+	case sizeBuckets:
+		w := uint(rnd.Intn(int(d.total)))
+		for i, bw := range d.weights {
+			if w < bw {
+				return d.sizes[i]
+			}
+			w -= bw
+		}
+	}
+	return d.max
+}
+
+func newKeySizes(dist *sizeDist, seed int64) *keySizes {
+	return &keySizes{dist: dist, seed: seed, rnd: mathrand.New(mathrand.NewSource(seed))}
+}
+
+// size of the object with index idx
+func (ks *keySizes) size(idx uint) int64 {
+	if ks.dist.kind == sizeFixed {
+		return ks.dist.max
+	}
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+	for uint(len(ks.sizes)) <= idx {
+		ks.sizes = append(ks.sizes, ks.dist.next(ks.rnd))
+	}
+	return ks.sizes[idx]
+}
+
+var dataHashes sync.Map
+
+// checksum of the first size bytes of the sample data
+func expectedHash(size int64) []byte {
+	if bufferBytes == nil || size == int64(len(bufferBytes)) {
+		return data_hash[:]
+	}
+	if h, ok := dataHashes.Load(size); ok {
+		return h.([]byte)
+	}
+	sum := sha512.Sum512(bufferBytes[:size])
+	dataHashes.Store(size, sum[:])
+	return sum[:]
+}
+
+// compare checksum of read data with the written one
+func validateHash(sum []byte, size int64) error {
+	expected := expectedHash(size)
+	if !bytes.Equal(sum, expected) {
+		return validationErrorf("Read data checksum %s is not eq to write data checksum %s", to_b32(sum), to_b32(expected))
+	}
+	return nil
+}
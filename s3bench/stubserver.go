@@ -0,0 +1,691 @@
+package s3bench
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const s3Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// In-memory S3 server implementing the subset of the API used by the tests,
+// with injected latency and errors
+type s3Stub struct {
+	mtx       sync.Mutex
+	buckets   map[string]map[string]*stubObject
+	uploads   map[string]*stubUpload
+	nextId    int64
+	latency   time.Duration
+	jitter    time.Duration // random latency added up to the value
+	errorRate float64       // share of requests failed with 503 SlowDown
+}
+
+type stubObject struct {
+	data     []byte
+	etag     string
+	tags     []stubTag
+	modified time.Time
+}
+
+type stubUpload struct {
+	bucket    string
+	key       string
+	parts     map[int64]*stubObject
+	initiated time.Time
+}
+
+type stubTag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type stubError struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource,omitempty"`
+	status   int
+}
+
+func newS3Stub(latency, jitter time.Duration, errorRate float64) *s3Stub {
+	return &s3Stub{
+		buckets:   make(map[string]map[string]*stubObject),
+		uploads:   make(map[string]*stubUpload),
+		latency:   latency,
+		jitter:    jitter,
+		errorRate: errorRate,
+	}
+}
+
+// In-memory S3 server for tests, latency and jitter are added to every
+// request, errorRate share of requests fails with 503 SlowDown
+func NewStubServer(latency, jitter time.Duration, errorRate float64) http.Handler {
+	return newS3Stub(latency, jitter, errorRate)
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	delay := s.latency
+	if s.jitter > 0 {
+		delay += time.Duration(mathrand.Int63n(int64(s.jitter)))
+	}
+	time.Sleep(delay)
+
+	// the request body is read before failing the request as real servers do
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if s.errorRate > 0 && mathrand.Float64() < s.errorRate {
+		s.writeError(w, r, &stubError{Code: "SlowDown", Message: "Injected error", status: http.StatusServiceUnavailable})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+	if bucket == "" {
+		s.writeError(w, r, &stubError{Code: "NotImplemented", Message: "Listing of buckets is not supported", status: http.StatusNotImplemented})
+		return
+	}
+
+	q := r.URL.Query()
+	var serr *stubError
+	if key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) && !hasParam(q, "tagging") {
+		// object data is sent without holding the lock
+		serr = s.getObject(w, r, bucket, key)
+		if serr != nil {
+			s.writeError(w, r, serr)
+		}
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodPut:
+		serr = s.createBucket(w, bucket)
+	case key == "" && r.Method == http.MethodDelete:
+		serr = s.deleteBucket(w, bucket)
+	case key == "" && r.Method == http.MethodGet && hasParam(q, "uploads"):
+		serr = s.listUploads(w, bucket, q)
+	case key == "" && r.Method == http.MethodGet:
+		serr = s.listObjects(w, bucket, q)
+	case key == "" && r.Method == http.MethodPost && hasParam(q, "delete"):
+		serr = s.deleteObjects(w, bucket, body)
+	case key == "":
+		serr = &stubError{Code: "NotImplemented", Message: "Unsupported bucket request", status: http.StatusNotImplemented}
+	case hasParam(q, "tagging"):
+		serr = s.tagging(w, r.Method, bucket, key, body)
+	case r.Method == http.MethodPost && hasParam(q, "uploads"):
+		serr = s.createUpload(w, bucket, key)
+	case r.Method == http.MethodPut && hasParam(q, "uploadId"):
+		serr = s.uploadPart(w, r, q, body)
+	case r.Method == http.MethodPost && hasParam(q, "uploadId"):
+		serr = s.completeUpload(w, bucket, key, q.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && hasParam(q, "uploadId"):
+		serr = s.abortUpload(w, q.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		serr = s.putObject(w, r, bucket, key, body)
+	case r.Method == http.MethodDelete:
+		serr = s.deleteObject(w, bucket, key)
+	default:
+		serr = &stubError{Code: "NotImplemented", Message: "Unsupported object request", status: http.StatusNotImplemented}
+	}
+	if serr != nil {
+		s.writeError(w, r, serr)
+	}
+}
+
+func hasParam(q url.Values, name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+func (s *s3Stub) writeError(w http.ResponseWriter, r *http.Request, e *stubError) {
+	e.Resource = r.URL.Path
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXml(w, e.status, e)
+}
+
+func writeXml(w http.ResponseWriter, status int, v interface{}) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+func noSuchBucket(bucket string) *stubError {
+	return &stubError{Code: "NoSuchBucket", Message: "The specified bucket does not exist: " + bucket, status: http.StatusNotFound}
+}
+
+func noSuchKey(key string) *stubError {
+	return &stubError{Code: "NoSuchKey", Message: "The specified key does not exist: " + key, status: http.StatusNotFound}
+}
+
+func malformedXml(err error) *stubError {
+	return &stubError{Code: "MalformedXML", Message: err.Error(), status: http.StatusBadRequest}
+}
+
+func newStubObject(data []byte) *stubObject {
+	sum := md5.Sum(data)
+	return &stubObject{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, modified: time.Now()}
+}
+
+func (s *s3Stub) object(bucket, key string) (*stubObject, *stubError) {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return nil, noSuchBucket(bucket)
+	}
+	obj, ok := objects[key]
+	if !ok {
+		return nil, noSuchKey(key)
+	}
+	return obj, nil
+}
+
+func (s *s3Stub) createBucket(w http.ResponseWriter, bucket string) *stubError {
+	if _, ok := s.buckets[bucket]; ok {
+		return &stubError{Code: "BucketAlreadyOwnedByYou", Message: "The bucket already exists: " + bucket, status: http.StatusConflict}
+	}
+	s.buckets[bucket] = make(map[string]*stubObject)
+	w.Header().Set("Location", "/"+bucket)
+	return nil
+}
+
+func (s *s3Stub) deleteBucket(w http.ResponseWriter, bucket string) *stubError {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return noSuchBucket(bucket)
+	}
+	if len(objects) > 0 {
+		return &stubError{Code: "BucketNotEmpty", Message: "The bucket is not empty: " + bucket, status: http.StatusConflict}
+	}
+	for _, u := range s.uploads {
+		if u.bucket == bucket {
+			return &stubError{Code: "BucketNotEmpty", Message: "The bucket has incomplete uploads: " + bucket, status: http.StatusConflict}
+		}
+	}
+	delete(s.buckets, bucket)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Source object of x-amz-copy-source header
+func (s *s3Stub) copySource(r *http.Request) (*stubObject, *stubError) {
+	src, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		return nil, &stubError{Code: "InvalidArgument", Message: err.Error(), status: http.StatusBadRequest}
+	}
+	i := strings.Index(src, "/")
+	if i < 0 {
+		return nil, &stubError{Code: "InvalidArgument", Message: "Invalid copy source " + src, status: http.StatusBadRequest}
+	}
+	return s.object(src[:i], src[i+1:])
+}
+
+type copyResult struct {
+	ETag         string `xml:"ETag"`
+	LastModified string `xml:"LastModified"`
+}
+
+func (s *s3Stub) putObject(w http.ResponseWriter, r *http.Request, bucket, key string, body []byte) *stubError {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return noSuchBucket(bucket)
+	}
+	if r.Header.Get("X-Amz-Copy-Source") == "" {
+		obj := newStubObject(body)
+		objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+		return nil
+	}
+
+	src, serr := s.copySource(r)
+	if serr != nil {
+		return serr
+	}
+	// objects are never modified, so the data is shared
+	obj := newStubObject(src.data)
+	objects[key] = obj
+	writeXml(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		copyResult
+	}{copyResult: copyResult{ETag: obj.etag, LastModified: obj.modified.UTC().Format(time.RFC3339)}})
+	return nil
+}
+
+// Parse "bytes=first-last" range of the object of given size
+func parseRange(spec string, size int64) (int64, int64, bool) {
+	if !strings.HasPrefix(spec, "bytes=") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(spec, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+	if bounds[0] == "" {
+		// suffix range
+		n, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, size > 0
+	}
+	first, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || first >= size {
+		return 0, 0, false
+	}
+	last := size - 1
+	if bounds[1] != "" {
+		last, err = strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || last < first {
+			return 0, 0, false
+		}
+		if last >= size {
+			last = size - 1
+		}
+	}
+	return first, last, true
+}
+
+func (s *s3Stub) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) *stubError {
+	s.mtx.Lock()
+	obj, serr := s.object(bucket, key)
+	s.mtx.Unlock()
+	if serr != nil {
+		return serr
+	}
+	size := int64(len(obj.data))
+	data := obj.data
+	status := http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" {
+		first, last, ok := parseRange(spec, size)
+		if !ok {
+			return &stubError{Code: "InvalidRange", Message: "The requested range is not satisfiable", status: http.StatusRequestedRangeNotSatisfiable}
+		}
+		data = obj.data[first : last+1]
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+	return nil
+}
+
+func (s *s3Stub) deleteObject(w http.ResponseWriter, bucket, key string) *stubError {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return noSuchBucket(bucket)
+	}
+	// deletion of missing object succeeds
+	delete(objects, key)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type stubTagging struct {
+	XMLName xml.Name  `xml:"Tagging"`
+	Xmlns   string    `xml:"xmlns,attr,omitempty"`
+	TagSet  []stubTag `xml:"TagSet>Tag"`
+}
+
+func (s *s3Stub) tagging(w http.ResponseWriter, method, bucket, key string, body []byte) *stubError {
+	obj, serr := s.object(bucket, key)
+	if serr != nil {
+		return serr
+	}
+	switch method {
+	case http.MethodGet:
+		writeXml(w, http.StatusOK, stubTagging{Xmlns: s3Xmlns, TagSet: obj.tags})
+	case http.MethodPut:
+		t := stubTagging{}
+		if err := xml.Unmarshal(body, &t); err != nil {
+			return malformedXml(err)
+		}
+		if len(t.TagSet) > 10 {
+			return &stubError{Code: "BadRequest", Message: "Object tags cannot be greater than 10", status: http.StatusBadRequest}
+		}
+		obj.tags = t.TagSet
+	case http.MethodDelete:
+		obj.tags = nil
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return &stubError{Code: "MethodNotAllowed", Message: "Unsupported tagging request", status: http.StatusMethodNotAllowed}
+	}
+	return nil
+}
+
+type listEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listResult struct {
+	XMLName               xml.Name     `xml:"ListBucketResult"`
+	Xmlns                 string       `xml:"xmlns,attr"`
+	Name                  string       `xml:"Name"`
+	Prefix                string       `xml:"Prefix"`
+	StartAfter            string       `xml:"StartAfter,omitempty"`
+	ContinuationToken     string       `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string       `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int          `xml:"KeyCount"`
+	MaxKeys               int          `xml:"MaxKeys"`
+	Delimiter             string       `xml:"Delimiter,omitempty"`
+	IsTruncated           bool         `xml:"IsTruncated"`
+	Contents              []listEntry  `xml:"Contents"`
+	CommonPrefixes        []listPrefix `xml:"CommonPrefixes"`
+}
+
+// ListObjectsV2, continuation token is the last listed key or prefix
+func (s *s3Stub) listObjects(w http.ResponseWriter, bucket string, q url.Values) *stubError {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return noSuchBucket(bucket)
+	}
+	res := listResult{
+		Xmlns:             s3Xmlns,
+		Name:              bucket,
+		Prefix:            q.Get("prefix"),
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: q.Get("continuation-token"),
+		Delimiter:         q.Get("delimiter"),
+		MaxKeys:           1000,
+	}
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return &stubError{Code: "InvalidArgument", Message: "Invalid max-keys " + v, status: http.StatusBadRequest}
+		}
+		res.MaxKeys = n
+	}
+	after := res.StartAfter
+	if res.ContinuationToken != "" {
+		after = res.ContinuationToken
+	}
+
+	keys := make([]string, 0, len(objects))
+	for k := range objects {
+		if strings.HasPrefix(k, res.Prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	last := ""
+	lastPrefix := false
+	for _, k := range keys {
+		prefix := ""
+		if res.Delimiter != "" {
+			if i := strings.Index(k[len(res.Prefix):], res.Delimiter); i >= 0 {
+				prefix = k[:len(res.Prefix)+i+len(res.Delimiter)]
+			}
+		}
+		if lastPrefix && prefix == last {
+			// rolled up to the previous common prefix
+			continue
+		}
+		if res.KeyCount == res.MaxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = last
+			if lastPrefix {
+				// keys of the prefix are greater than the prefix itself
+				res.NextContinuationToken += "\U0010FFFF"
+			}
+			break
+		}
+		if prefix != "" {
+			res.CommonPrefixes = append(res.CommonPrefixes, listPrefix{prefix})
+			last = prefix
+		} else {
+			obj := objects[k]
+			res.Contents = append(res.Contents, listEntry{
+				Key:          k,
+				LastModified: obj.modified.UTC().Format(time.RFC3339),
+				ETag:         obj.etag,
+				Size:         int64(len(obj.data)),
+				StorageClass: "STANDARD",
+			})
+			last = k
+		}
+		lastPrefix = prefix != ""
+		res.KeyCount++
+	}
+	writeXml(w, http.StatusOK, res)
+	return nil
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedKey struct {
+	Key string `xml:"Key"`
+}
+
+type deleteKeyError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (s *s3Stub) deleteObjects(w http.ResponseWriter, bucket string, body []byte) *stubError {
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return noSuchBucket(bucket)
+	}
+	dr := deleteRequest{}
+	if err := xml.Unmarshal(body, &dr); err != nil {
+		return malformedXml(err)
+	}
+	if len(dr.Objects) > 1000 {
+		return malformedXml(fmt.Errorf("cannot delete more than 1000 objects at once"))
+	}
+	res := struct {
+		XMLName xml.Name         `xml:"DeleteResult"`
+		Xmlns   string           `xml:"xmlns,attr"`
+		Deleted []deletedKey     `xml:"Deleted"`
+		Errors  []deleteKeyError `xml:"Error"`
+	}{Xmlns: s3Xmlns}
+	for _, o := range dr.Objects {
+		delete(objects, o.Key)
+		if !dr.Quiet {
+			res.Deleted = append(res.Deleted, deletedKey{o.Key})
+		}
+	}
+	writeXml(w, http.StatusOK, res)
+	return nil
+}
+
+func (s *s3Stub) createUpload(w http.ResponseWriter, bucket, key string) *stubError {
+	if _, ok := s.buckets[bucket]; !ok {
+		return noSuchBucket(bucket)
+	}
+	s.nextId++
+	id := strconv.FormatInt(s.nextId, 10)
+	s.uploads[id] = &stubUpload{bucket: bucket, key: key, parts: make(map[int64]*stubObject), initiated: time.Now()}
+	writeXml(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadId string   `xml:"UploadId"`
+	}{Xmlns: s3Xmlns, Bucket: bucket, Key: key, UploadId: id})
+	return nil
+}
+
+func (s *s3Stub) upload(id string) (*stubUpload, *stubError) {
+	u, ok := s.uploads[id]
+	if !ok {
+		return nil, &stubError{Code: "NoSuchUpload", Message: "The specified upload does not exist: " + id, status: http.StatusNotFound}
+	}
+	return u, nil
+}
+
+// UploadPart or UploadPartCopy
+func (s *s3Stub) uploadPart(w http.ResponseWriter, r *http.Request, q url.Values, body []byte) *stubError {
+	u, serr := s.upload(q.Get("uploadId"))
+	if serr != nil {
+		return serr
+	}
+	pn, err := strconv.ParseInt(q.Get("partNumber"), 10, 64)
+	if err != nil || pn < 1 || pn > 10000 {
+		return &stubError{Code: "InvalidArgument", Message: "Invalid part number " + q.Get("partNumber"), status: http.StatusBadRequest}
+	}
+	if r.Header.Get("X-Amz-Copy-Source") == "" {
+		part := newStubObject(body)
+		u.parts[pn] = part
+		w.Header().Set("ETag", part.etag)
+		return nil
+	}
+
+	src, serr := s.copySource(r)
+	if serr != nil {
+		return serr
+	}
+	data := src.data
+	if spec := r.Header.Get("X-Amz-Copy-Source-Range"); spec != "" {
+		first, last, ok := parseRange(spec, int64(len(data)))
+		if !ok {
+			return &stubError{Code: "InvalidArgument", Message: "Invalid copy source range " + spec, status: http.StatusBadRequest}
+		}
+		data = data[first : last+1]
+	}
+	part := newStubObject(data)
+	u.parts[pn] = part
+	writeXml(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"CopyPartResult"`
+		copyResult
+	}{copyResult: copyResult{ETag: part.etag, LastModified: part.modified.UTC().Format(time.RFC3339)}})
+	return nil
+}
+
+func (s *s3Stub) completeUpload(w http.ResponseWriter, bucket, key, id string, body []byte) *stubError {
+	u, serr := s.upload(id)
+	if serr != nil {
+		return serr
+	}
+	cu := struct {
+		Parts []struct {
+			PartNumber int64  `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}{}
+	if err := xml.Unmarshal(body, &cu); err != nil {
+		return malformedXml(err)
+	}
+	if len(cu.Parts) == 0 {
+		return malformedXml(fmt.Errorf("no parts"))
+	}
+	data := []byte{}
+	prev := int64(0)
+	for _, p := range cu.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok || part.etag != p.ETag {
+			return &stubError{Code: "InvalidPart", Message: fmt.Sprintf("Part %d is not uploaded", p.PartNumber), status: http.StatusBadRequest}
+		}
+		if p.PartNumber <= prev {
+			return &stubError{Code: "InvalidPartOrder", Message: "Parts should be in ascending order", status: http.StatusBadRequest}
+		}
+		prev = p.PartNumber
+		data = append(data, part.data...)
+	}
+	objects, ok := s.buckets[u.bucket]
+	if !ok {
+		return noSuchBucket(u.bucket)
+	}
+	obj := newStubObject(data)
+	objects[u.key] = obj
+	delete(s.uploads, id)
+	writeXml(w, http.StatusOK, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string   `xml:"Location"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		ETag     string   `xml:"ETag"`
+	}{Xmlns: s3Xmlns, Location: "/" + bucket + "/" + key, Bucket: bucket, Key: key, ETag: obj.etag})
+	return nil
+}
+
+func (s *s3Stub) abortUpload(w http.ResponseWriter, id string) *stubError {
+	if _, serr := s.upload(id); serr != nil {
+		return serr
+	}
+	delete(s.uploads, id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type uploadEntry struct {
+	Key       string `xml:"Key"`
+	UploadId  string `xml:"UploadId"`
+	Initiated string `xml:"Initiated"`
+}
+
+// ListMultipartUploads, all uploads are returned in a single page
+func (s *s3Stub) listUploads(w http.ResponseWriter, bucket string, q url.Values) *stubError {
+	if _, ok := s.buckets[bucket]; !ok {
+		return noSuchBucket(bucket)
+	}
+	res := struct {
+		XMLName     xml.Name      `xml:"ListMultipartUploadsResult"`
+		Xmlns       string        `xml:"xmlns,attr"`
+		Bucket      string        `xml:"Bucket"`
+		Prefix      string        `xml:"Prefix"`
+		IsTruncated bool          `xml:"IsTruncated"`
+		Uploads     []uploadEntry `xml:"Upload"`
+	}{Xmlns: s3Xmlns, Bucket: bucket, Prefix: q.Get("prefix")}
+	for id, u := range s.uploads {
+		if u.bucket == bucket && strings.HasPrefix(u.key, res.Prefix) {
+			res.Uploads = append(res.Uploads, uploadEntry{u.key, id, u.initiated.UTC().Format(time.RFC3339)})
+		}
+	}
+	sort.Slice(res.Uploads, func(i, j int) bool {
+		if res.Uploads[i].Key != res.Uploads[j].Key {
+			return res.Uploads[i].Key < res.Uploads[j].Key
+		}
+		return res.Uploads[i].UploadId < res.Uploads[j].UploadId
+	})
+	writeXml(w, http.StatusOK, res)
+	return nil
+}
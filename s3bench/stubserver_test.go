@@ -0,0 +1,300 @@
+package s3bench
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubClient struct {
+	t   *testing.T
+	url string
+}
+
+func newStubClient(t *testing.T, s *s3Stub) *stubClient {
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return &stubClient{t: t, url: srv.URL}
+}
+
+// Send the request, returns the response with its body read
+func (c *stubClient) do(method, path, body string, header map[string]string) (*http.Response, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.url+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+// Send the request and check the response status
+func (c *stubClient) expect(status int, method, path, body string, header map[string]string) (*http.Response, string) {
+	c.t.Helper()
+	resp, b := c.do(method, path, body, header)
+	if resp.StatusCode != status {
+		c.t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, resp.StatusCode, b)
+	}
+	return resp, b
+}
+
+// Send the request and check the error code
+func (c *stubClient) expectError(code string, method, path, body string) {
+	c.t.Helper()
+	_, b := c.do(method, path, body, nil)
+	e := stubError{}
+	if err := xml.Unmarshal([]byte(b), &e); err != nil {
+		c.t.Fatalf("%s %s: %v: %s", method, path, err, b)
+	}
+	if e.Code != code {
+		c.t.Fatalf("%s %s: expected error %s, got %s", method, path, code, e.Code)
+	}
+}
+
+func TestStubBuckets(t *testing.T) {
+	c := newStubClient(t, newS3Stub(0, 0, 0))
+	c.expect(200, "PUT", "/b", "", nil)
+	c.expectError("BucketAlreadyOwnedByYou", "PUT", "/b", "")
+	c.expect(200, "PUT", "/b/obj", "data", nil)
+	c.expectError("BucketNotEmpty", "DELETE", "/b", "")
+	c.expect(204, "DELETE", "/b/obj", "", nil)
+	c.expect(204, "DELETE", "/b", "", nil)
+	c.expectError("NoSuchBucket", "DELETE", "/b", "")
+	c.expectError("NoSuchBucket", "PUT", "/b/obj", "data")
+}
+
+func TestStubObjects(t *testing.T) {
+	c := newStubClient(t, newS3Stub(0, 0, 0))
+	c.expect(200, "PUT", "/b", "", nil)
+	resp, _ := c.expect(200, "PUT", "/b/dir/obj", "0123456789", nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag of written object")
+	}
+
+	resp, b := c.expect(200, "GET", "/b/dir/obj", "", nil)
+	if b != "0123456789" || resp.Header.Get("ETag") != etag {
+		t.Fatalf("read %q, ETag %s", b, resp.Header.Get("ETag"))
+	}
+	resp, _ = c.expect(200, "HEAD", "/b/dir/obj", "", nil)
+	if resp.ContentLength != 10 {
+		t.Fatalf("head length %d", resp.ContentLength)
+	}
+
+	ranges := []struct{ spec, data, contentRange string }{
+		{"bytes=2-4", "234", "bytes 2-4/10"},
+		{"bytes=7-", "789", "bytes 7-9/10"},
+		{"bytes=-2", "89", "bytes 8-9/10"},
+		{"bytes=8-20", "89", "bytes 8-9/10"},
+	}
+	for _, rng := range ranges {
+		resp, b = c.expect(206, "GET", "/b/dir/obj", "", map[string]string{"Range": rng.spec})
+		if b != rng.data || resp.Header.Get("Content-Range") != rng.contentRange {
+			t.Fatalf("range %s: read %q of %s", rng.spec, b, resp.Header.Get("Content-Range"))
+		}
+	}
+	c.expect(416, "GET", "/b/dir/obj", "", map[string]string{"Range": "bytes=10-11"})
+
+	_, b = c.expect(200, "PUT", "/b/copy", "", map[string]string{"X-Amz-Copy-Source": "b/dir%2Fobj"})
+	copyRes := struct{ ETag string }{}
+	if err := xml.Unmarshal([]byte(b), &copyRes); err != nil || copyRes.ETag != etag {
+		t.Fatalf("copy result %s (%v)", b, err)
+	}
+	_, b = c.expect(200, "GET", "/b/copy", "", nil)
+	if b != "0123456789" {
+		t.Fatalf("read copy %q", b)
+	}
+
+	c.expect(204, "DELETE", "/b/dir/obj", "", nil)
+	c.expectError("NoSuchKey", "GET", "/b/dir/obj", "")
+	c.expect(404, "HEAD", "/b/dir/obj", "", nil)
+	// deletion of missing object succeeds
+	c.expect(204, "DELETE", "/b/dir/obj", "", nil)
+}
+
+func TestStubTagging(t *testing.T) {
+	c := newStubClient(t, newS3Stub(0, 0, 0))
+	c.expect(200, "PUT", "/b", "", nil)
+	c.expect(200, "PUT", "/b/obj", "data", nil)
+	c.expect(200, "PUT", "/b/obj?tagging", "<Tagging><TagSet><Tag><Key>k1</Key><Value>v1</Value></Tag><Tag><Key>k2</Key><Value>v2</Value></Tag></TagSet></Tagging>", nil)
+
+	_, b := c.expect(200, "GET", "/b/obj?tagging", "", nil)
+	tagging := stubTagging{}
+	if err := xml.Unmarshal([]byte(b), &tagging); err != nil {
+		t.Fatal(err)
+	}
+	if len(tagging.TagSet) != 2 || tagging.TagSet[1] != (stubTag{"k2", "v2"}) {
+		t.Fatalf("tags %v", tagging.TagSet)
+	}
+
+	c.expect(204, "DELETE", "/b/obj?tagging", "", nil)
+	_, b = c.expect(200, "GET", "/b/obj?tagging", "", nil)
+	if strings.Contains(b, "<Tag>") {
+		t.Fatalf("tags are not deleted: %s", b)
+	}
+	c.expectError("NoSuchKey", "GET", "/b/missing?tagging", "")
+	c.expectError("MalformedXML", "PUT", "/b/obj?tagging", "<Tagging>")
+}
+
+// List all pages, returns listed keys and common prefixes
+func (c *stubClient) list(query string) ([]string, int) {
+	c.t.Helper()
+	keys := []string{}
+	token := ""
+	pages := 0
+	for {
+		path := "/b?list-type=2&" + query
+		if token != "" {
+			path += "&continuation-token=" + url.QueryEscape(token)
+		}
+		_, b := c.expect(200, "GET", path, "", nil)
+		pages++
+		res := listResult{}
+		if err := xml.Unmarshal([]byte(b), &res); err != nil {
+			c.t.Fatal(err)
+		}
+		if res.KeyCount != len(res.Contents)+len(res.CommonPrefixes) {
+			c.t.Fatalf("key count %d of %s", res.KeyCount, b)
+		}
+		for _, e := range res.Contents {
+			keys = append(keys, e.Key)
+		}
+		for _, p := range res.CommonPrefixes {
+			keys = append(keys, p.Prefix)
+		}
+		if !res.IsTruncated {
+			sort.Strings(keys)
+			return keys, pages
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func TestStubList(t *testing.T) {
+	c := newStubClient(t, newS3Stub(0, 0, 0))
+	c.expect(200, "PUT", "/b", "", nil)
+	for _, k := range []string{"a/1", "a/2", "b/1", "c", "d/1", "d/2", "e", "x"} {
+		c.expect(200, "PUT", "/b/"+k, "data", nil)
+	}
+
+	tests := []struct {
+		query string
+		keys  string
+		pages int
+	}{
+		{"", "a/1,a/2,b/1,c,d/1,d/2,e,x", 1},
+		{"max-keys=3", "a/1,a/2,b/1,c,d/1,d/2,e,x", 3},
+		{"prefix=a/&max-keys=1", "a/1,a/2", 2},
+		{"delimiter=/", "a/,b/,c,d/,e,x", 1},
+		{"delimiter=/&max-keys=1", "a/,b/,c,d/,e,x", 6},
+		{"delimiter=/&max-keys=2&start-after=a/2", "b/,c,d/,e,x", 3},
+		{"prefix=z", "", 1},
+	}
+	for _, tt := range tests {
+		keys, pages := c.list(tt.query)
+		if strings.Join(keys, ",") != tt.keys || pages != tt.pages {
+			t.Errorf("list %s: got %v in %d pages, expected %s in %d pages", tt.query, keys, pages, tt.keys, tt.pages)
+		}
+	}
+}
+
+func TestStubDeleteObjects(t *testing.T) {
+	c := newStubClient(t, newS3Stub(0, 0, 0))
+	c.expect(200, "PUT", "/b", "", nil)
+	for _, k := range []string{"1", "2", "3"} {
+		c.expect(200, "PUT", "/b/"+k, "data", nil)
+	}
+	_, b := c.expect(200, "POST", "/b?delete", "<Delete><Object><Key>1</Key></Object><Object><Key>3</Key></Object><Object><Key>4</Key></Object></Delete>", nil)
+	if strings.Count(b, "<Deleted>") != 3 {
+		t.Fatalf("delete result %s", b)
+	}
+	keys, _ := c.list("")
+	if strings.Join(keys, ",") != "2" {
+		t.Fatalf("keys left %v", keys)
+	}
+	_, b = c.expect(200, "POST", "/b?delete", "<Delete><Quiet>true</Quiet><Object><Key>2</Key></Object></Delete>", nil)
+	if strings.Contains(b, "<Deleted>") {
+		t.Fatalf("quiet delete result %s", b)
+	}
+	c.expect(204, "DELETE", "/b", "", nil)
+}
+
+func TestStubMultipart(t *testing.T) {
+	c := newStubClient(t, newS3Stub(0, 0, 0))
+	c.expect(200, "PUT", "/b", "", nil)
+	c.expect(200, "PUT", "/b/src", "0123456789", nil)
+
+	initiate := func(key string) string {
+		_, b := c.expect(200, "POST", "/b/"+key+"?uploads", "", nil)
+		res := struct{ UploadId string }{}
+		if err := xml.Unmarshal([]byte(b), &res); err != nil || res.UploadId == "" {
+			t.Fatalf("initiate result %s (%v)", b, err)
+		}
+		return res.UploadId
+	}
+	id := initiate("obj")
+	resp1, _ := c.expect(200, "PUT", "/b/obj?partNumber=1&uploadId="+id, "abc", nil)
+	_, b := c.expect(200, "PUT", "/b/obj?partNumber=2&uploadId="+id, "", map[string]string{
+		"X-Amz-Copy-Source":       "/b/src",
+		"X-Amz-Copy-Source-Range": "bytes=5-9",
+	})
+	part2 := struct{ ETag string }{}
+	if err := xml.Unmarshal([]byte(b), &part2); err != nil || part2.ETag == "" {
+		t.Fatalf("copy part result %s (%v)", b, err)
+	}
+
+	abortId := initiate("aborted")
+	_, b = c.expect(200, "GET", "/b?uploads", "", nil)
+	if strings.Count(b, "<Upload>") != 2 || !strings.Contains(b, abortId) {
+		t.Fatalf("uploads %s", b)
+	}
+	c.expectError("BucketNotEmpty", "DELETE", "/b", "")
+	c.expect(204, "DELETE", "/b/aborted?uploadId="+abortId, "", nil)
+	c.expectError("NoSuchUpload", "DELETE", "/b/aborted?uploadId="+abortId, "")
+
+	c.expectError("InvalidPart", "POST", "/b/obj?uploadId="+id,
+		"<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>wrong</ETag></Part></CompleteMultipartUpload>")
+	c.expect(200, "POST", "/b/obj?uploadId="+id, "<CompleteMultipartUpload>"+
+		"<Part><PartNumber>1</PartNumber><ETag>"+resp1.Header.Get("ETag")+"</ETag></Part>"+
+		"<Part><PartNumber>2</PartNumber><ETag>"+part2.ETag+"</ETag></Part>"+
+		"</CompleteMultipartUpload>", nil)
+	_, b = c.expect(200, "GET", "/b/obj", "", nil)
+	if b != "abc56789" {
+		t.Fatalf("read %q", b)
+	}
+	_, b = c.expect(200, "GET", "/b?uploads", "", nil)
+	if strings.Contains(b, "<Upload>") {
+		t.Fatalf("uploads left %s", b)
+	}
+}
+
+func TestStubInjected(t *testing.T) {
+	c := newStubClient(t, newS3Stub(0, 0, 1))
+	c.expectError("SlowDown", "PUT", "/b", "")
+	c.expect(503, "HEAD", "/b/obj", "", nil)
+
+	latency := 20 * time.Millisecond
+	c = newStubClient(t, newS3Stub(latency, latency, 0))
+	start := time.Now()
+	c.expect(200, "PUT", "/b", "", nil)
+	if d := time.Since(start); d < latency {
+		t.Fatalf("request took %s, latency is %s", d, latency)
+	}
+}
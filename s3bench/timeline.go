@@ -0,0 +1,78 @@
+package s3bench
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Stats of requests completed within a time window of a test
+type timelineWindow struct {
+	start     time.Duration // since the test start
+	length    time.Duration
+	numOps    int64
+	numBytes  int64
+	numErrors int64
+	durations *Histogram
+	// percentiles are kept after the window is closed instead of histogram
+	p50 float64
+	p90 float64
+	p99 float64
+	max float64
+}
+
+func (w *timelineWindow) add(resp Resp) {
+	w.numOps++
+	if resp.err != nil {
+		w.numErrors++
+		return
+	}
+	w.numErrors += int64(len(resp.keyErrors))
+	w.numBytes += resp.numBytes
+	w.durations.record(resp.duration)
+}
+
+func (w timelineWindow) opsRate() float64 {
+	return float64(w.numOps) / w.length.Seconds()
+}
+
+func (w timelineWindow) throughput() float64 {
+	return float64(w.numBytes) / (1024 * 1024) / w.length.Seconds()
+}
+
+func (w timelineWindow) report() map[string]interface{} {
+	ret := make(map[string]interface{})
+	ret["Start (s)"] = w.start.Seconds()
+	ret["Length (s)"] = w.length.Seconds()
+	ret["Requests Count"] = w.numOps
+	ret["Errors Count"] = w.numErrors
+	ret["Rate (ops/s)"] = w.opsRate()
+	ret["Throughput (MB/s)"] = w.throughput()
+	ret["Duration 50th-ile"] = w.p50
+	ret["Duration 90th-ile"] = w.p90
+	ret["Duration 99th-ile"] = w.p99
+	ret["Duration Max"] = w.max
+	return ret
+}
+
+// Start new window of the result timeline
+func (result *testResult) startWindow(start time.Duration) {
+	result.window = &timelineWindow{start: start, durations: newHistogram()}
+}
+
+// Close current window of the result timeline at now and print it to stderr
+func (result *testResult) closeWindow(now time.Duration) {
+	w := *result.window
+	w.length = now - w.start
+	if w.length <= 0 {
+		return
+	}
+	w.p50 = w.durations.percentile(50)
+	w.p90 = w.durations.percentile(90)
+	w.p99 = w.durations.percentile(99)
+	w.max = w.durations.percentile(100)
+	w.durations = nil
+	result.timeline = append(result.timeline, w)
+	fmt.Fprintf(os.Stderr, "[%7.1fs] %-12s %10.1f ops/s %10.2f MB/s %6d errors | p50 %.3fs p90 %.3fs p99 %.3fs max %.3fs\n",
+		now.Seconds(), result.operation, w.opsRate(), w.throughput(), w.numErrors, w.p50, w.p90, w.p99, w.max)
+}
@@ -0,0 +1,177 @@
+package s3bench
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	traceCSV   = "csv"
+	traceJSONL = "jsonl"
+)
+
+// Record of a completed request in the trace file
+type traceRecord struct {
+	Timestamp string  `json:"timestamp"`
+	Operation string  `json:"operation"`
+	Key       string  `json:"key"`
+	Endpoint  string  `json:"endpoint"`
+	ClientId  uint    `json:"clientId"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"duration"`
+	Ttfb      float64 `json:"ttfb"`
+	Status    int     `json:"status"`
+	Error     string  `json:"error"`
+	Retries   int64   `json:"retries"`
+}
+
+// Writes per-request trace records to file in background
+type traceWriter struct {
+	records chan traceRecord
+	done    chan error
+}
+
+func newTraceWriter(fileName string, format string) (*traceWriter, error) {
+	if format != traceCSV && format != traceJSONL {
+		return nil, fmt.Errorf("unknown trace format %s", format)
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	tw := &traceWriter{
+		records: make(chan traceRecord, 65536),
+		done:    make(chan error, 1),
+	}
+	go tw.write(f, format)
+	return tw, nil
+}
+
+func (tw *traceWriter) write(f *os.File, format string) {
+	bw := bufio.NewWriterSize(f, 1024*1024)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	var err error
+	if format == traceCSV {
+		err = cw.Write([]string{"timestamp", "operation", "key", "endpoint", "clientId",
+			"bytes", "duration", "ttfb", "status", "error", "retries"})
+	}
+	for rec := range tw.records {
+		if err != nil {
+			// drain the records to not block the test
+			continue
+		}
+		if format == traceCSV {
+			err = cw.Write([]string{rec.Timestamp, rec.Operation, rec.Key, rec.Endpoint,
+				strconv.FormatUint(uint64(rec.ClientId), 10),
+				strconv.FormatInt(rec.Bytes, 10),
+				strconv.FormatFloat(rec.Duration, 'f', 6, 64),
+				strconv.FormatFloat(rec.Ttfb, 'f', 6, 64),
+				strconv.Itoa(rec.Status), rec.Error,
+				strconv.FormatInt(rec.Retries, 10)})
+		} else {
+			err = enc.Encode(rec)
+		}
+	}
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	tw.done <- err
+}
+
+// Queue the completed request to be written, the record is dropped if the
+// queue is full so a slow disk does not delay collection of responses.
+// Returns false if the record is dropped
+func (tw *traceWriter) add(resp Resp) bool {
+	rec := traceRecord{
+		Timestamp: resp.start.Format(time.RFC3339Nano),
+		Operation: resp.top,
+		Key:       resp.key,
+		Endpoint:  resp.endpoint,
+		ClientId:  resp.clientId,
+		Bytes:     resp.numBytes,
+		Duration:  resp.duration.Seconds(),
+		Ttfb:      resp.ttfb.Seconds(),
+		Status:    resp.status,
+		Retries:   resp.retries,
+	}
+	if resp.err != nil {
+		rec.Error = resp.err.Error()
+	}
+	select {
+	case tw.records <- rec:
+		return true
+	default:
+		return false
+	}
+}
+
+// Write the queued records and close the file
+func (tw *traceWriter) close() error {
+	close(tw.records)
+	return <-tw.done
+}
+
+// HTTP status of the sent request
+func httpStatus(req *request.Request) int {
+	if req.HTTPResponse == nil {
+		return 0
+	}
+	return req.HTTPResponse.StatusCode
+}
+
+// HTTP status of the request completed with err, when it is not known
+// from the response
+func errStatus(err error) int {
+	var rerr awserr.RequestFailure
+	if err == nil {
+		return 200
+	} else if errors.As(err, &rerr) {
+		return rerr.StatusCode()
+	}
+	return 0
+}
+
+// Object key of the request, key prefix for listing and empty string for
+// multi-object requests
+func requestKey(req interface{}) string {
+	switch r := req.(type) {
+	case *s3.PutObjectInput:
+		return aws.StringValue(r.Key)
+	case *s3.CreateMultipartUploadInput:
+		return aws.StringValue(r.Key)
+	case *s3.GetObjectInput:
+		return aws.StringValue(r.Key)
+	case *s3.HeadObjectInput:
+		return aws.StringValue(r.Key)
+	case *s3.ListObjectsV2Input:
+		return aws.StringValue(r.Prefix)
+	case *s3.CopyObjectInput:
+		return aws.StringValue(r.Key)
+	case *s3.DeleteObjectInput:
+		return aws.StringValue(r.Key)
+	case *s3.PutObjectTaggingInput:
+		return aws.StringValue(r.Key)
+	case *s3.GetObjectTaggingInput:
+		return aws.StringValue(r.Key)
+	}
+	return ""
+}
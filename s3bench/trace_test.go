@@ -0,0 +1,46 @@
+package s3bench
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceWriter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trace.csv")
+	tw, err := newTraceWriter(fileName, traceCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		tw.add(Resp{top: opRead, key: "key", start: time.Now(), duration: time.Millisecond, status: 200})
+	}
+	if err = tw.close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 11 {
+		t.Errorf("%d lines in the trace", lines)
+	}
+}
+
+func TestTraceWriterDrop(t *testing.T) {
+	// the writer is stuck, the queue has room for one record
+	tw := &traceWriter{records: make(chan traceRecord, 1), done: make(chan error, 1)}
+	if !tw.add(Resp{top: opRead}) {
+		t.Error("first record is dropped")
+	}
+	if tw.add(Resp{top: opRead}) {
+		t.Error("second record is queued")
+	}
+	// dropped records are not an error of the trace file
+	tw.done <- nil
+	if err := tw.close(); err != nil {
+		t.Errorf("close error %v", err)
+	}
+}
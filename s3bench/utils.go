@@ -0,0 +1,172 @@
+package s3bench
+
+import (
+	"fmt"
+	"strconv"
+	"regexp"
+	"strings"
+	"encoding/base32"
+	mathrand "math/rand"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+)
+
+func to_b32(dt []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(dt)
+}
+
+func from_b32(s string) ([]byte, error) {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+}
+
+func parse_size(sz string) int64 {
+	sizes := map[string]int64 {
+		"b": 1,
+		"Kb": 1024,
+		"Mb": 1024 * 1024,
+		"Gb": 1024 * 1024 * 1024,
+	}
+	re := regexp.MustCompile(`^(\d+)([bKMG]{1,2})$`)
+	mm := re.FindStringSubmatch(sz)
+	if len(mm) != 3 {
+		panic("Invalid objectSize value format\n")
+	}
+	val, err := strconv.ParseInt(string(mm[1]), 10, 64)
+	mult, ex := sizes[string(mm[2])]
+	if !ex || err != nil {
+		panic("Invalid objectSize value\n")
+	}
+	return val * mult
+}
+
+func (params Params) printf(f string, args ...interface{}) {
+	if params.verbose {
+		fmt.Printf(f, args...)
+	}
+}
+
+// index of the object accessed by i-th request of operation op
+func (params Params) keyIndex(op operation, i uint) uint {
+	if params.duration > 0 && params.test != opMix && op.WritesObjects() {
+		// new objects are written until the duration expires, writes of
+		// the mix overwrite the objects read by the mix
+		return i
+	}
+	if op.UsesKeyDist() {
+		return params.keys.next(i, params.numSamples)
+	}
+	return i % params.numSamples
+}
+
+// offset and length of the i-th ranged read of the object of objSize
+func (params Params) rangeFor(i uint, objSize int64) (int64, int64) {
+	var offset int64
+	switch params.rangePattern {
+	case rangeFixed:
+		offset = params.rangeOffset
+	case rangeRandom:
+		if objSize > params.rangeSize {
+			offset = mathrand.Int63n(objSize - params.rangeSize + 1)
+		}
+	case rangeSequential:
+		// every next pass over the objects reads the next range
+		offset = (int64(i / params.numSamples) * params.rangeSize) % objSize
+	}
+	size := params.rangeSize
+	if offset + size > objSize {
+		size = objSize - offset
+	}
+	return offset, size
+}
+
+// operations by the names used in -mix, -ops and scenarios: short aliases
+// and lower case operation names
+func (params Params) opNames() map[string]string {
+	writeOp := opWrite
+	if params.partSize > 0 {
+		writeOp = opMpWrite
+	}
+	readOp := opRead
+	if params.rangeSize > 0 {
+		readOp = opRangedRead
+	}
+	names := map[string]string{}
+	for _, op := range OperationNames() {
+		names[strings.ToLower(op)] = op
+	}
+	// aliases override lower case names of read and write
+	names["read"] = readOp
+	names["write"] = writeOp
+	names["head"] = opHeadObj
+	names["puttag"] = opPutObjTag
+	names["gettag"] = opGetObjTag
+	return names
+}
+
+// parse mix of operations like "read=70,write=20,head=10"
+func (params Params) parseMix(mix string) ([]mixOp, error) {
+	names := params.opNames()
+	ret := []mixOp{}
+	var totalWeight uint
+	for _, item := range strings.Split(mix, ",") {
+		kv := strings.Split(strings.TrimSpace(item), "=")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid item %q, expected op=weight", item)
+		}
+		op, ok := names[strings.ToLower(kv[0])]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q", kv[0])
+		}
+		if op == opList || op == opCopy || op == opDelete || op == opBatchDelete {
+			return nil, fmt.Errorf("%s cannot be mixed", op)
+		}
+		weight, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight of %s: %v", kv[0], err)
+		}
+		for _, m := range ret {
+			if m.op == op {
+				return nil, fmt.Errorf("duplicate operation %q", kv[0])
+			}
+		}
+		ret = append(ret, mixOp{op, uint(weight)})
+		totalWeight += uint(weight)
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("total weight cannot be 0")
+	}
+	return ret, nil
+}
+
+func genObjName(pref string, hsh string, idx uint) *string {
+	return aws.String(fmt.Sprintf("%s_%s_%d", pref, hsh, idx))
+}
+
+func (params *Params) getObjectHash(cfg *aws.Config) (string, error){
+	cfg.Endpoint = aws.String(params.endpoints[0])
+	svc := s3.New(session.New(), cfg)
+
+	result, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket: aws.String(params.bucketName),
+		MaxKeys: aws.Int64(1),
+		Prefix: aws.String(params.objectNamePrefix),
+	})
+
+	if err != nil {
+		return "", err
+	}
+	if len(result.Contents) == 0 {
+		return "", fmt.Errorf("Empty bucket")
+	}
+
+	re := regexp.MustCompile(`^.*_([A-Z2-7]+)_[0-9]+$`)
+	mm := re.FindStringSubmatch(*result.Contents[0].Key)
+	if len(mm) != 2 {
+		return "", fmt.Errorf("Invalid object name format")
+	}
+
+	return mm[1], nil
+}